@@ -5,101 +5,315 @@ package wintun
 
 import (
 	"context"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"syscall"
-
-	"github.com/pkg/errors"
+	"time"
 
 	"unsafe"
 
+	"github.com/pkg/errors"
 	"golang.org/x/sys/windows"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
 
 type Adapter struct {
-	// when the handle/session is being used(recv/send etc.), can't Stop/Close,
-	// reference uint test Test_Recving_Close
+	// serialize Start/Stop/Close, the hot path Recv/Release/Alloc/Send not use
+	// it, they reference session by atomic, so Stop/Close can't end session that
+	// is being used, reference unit test Test_Recving_Close
 	mu sync.RWMutex
 
 	handle  uintptr
-	session uintptr
+	closed  atomic.Bool
+	session atomic.Pointer[session]
+	// adaptive ring restarting session, see resize
+	restarting atomic.Bool
+	// session being stopped, Stop/Close wait it drained without lock
+	stopping *session
+
+	// ownership marker and GUID of adapter created by CreateAdapter
+	owner     windows.Handle
+	ownerGuid windows.GUID
+
+	// closed when adapter Close, used by background goroutines
+	done chan struct{}
+
+	// route managers, their routes are removed when adapter Close
+	routes []*RouteManager
+
+	// NRPT rules set, they are removed when adapter Close
+	nrpt bool
+
+	// MTU change notifiers, they are stopped when adapter Close
+	notifiers []*mtuNotifier
+
+	stats struct {
+		recv, recvWait, alloc, ringFull atomic.Uint64
+	}
 }
 
-func (a *Adapter) sessionLocked(trap uintptr, args ...uintptr) (r1, r2 uintptr, err error) {
-	if a.handle == 0 {
-		return 0, 0, errors.WithStack(ErrAdapterClosed{})
-	} else if a.session == 0 {
-		return 0, 0, errors.WithStack(ErrAdapterStoped{})
+type session struct {
+	*sessionRef
+
+	handle   uintptr
+	capacity uint32
+
+	// read wait event and stop event, stop event is set when session ending,
+	// to wake up Recv waiters immediately
+	events [2]windows.Handle
+}
+
+// acquire reference of current session, must release after used
+func (a *Adapter) acquire() (*session, error) {
+	for {
+		if a.closed.Load() {
+			return nil, errors.WithStack(ErrAdapterClosed{})
+		}
+		s := a.session.Load()
+		if s == nil {
+			return nil, errors.WithStack(ErrAdapterStoped{})
+		} else if s.acquire() {
+			return s, nil
+		} else if a.session.Load() != s {
+			continue // replaced by new session
+		} else if a.restarting.Load() {
+			// wait restart finished
+			a.mu.RLock()
+			a.mu.RUnlock()
+			continue
+		}
+		// the session is ending, wait outstanding packets released
+		return nil, errors.WithStack(ErrAdapterStoped{})
 	}
-	r1, r2, err = syscall.SyscallN(trap, append([]uintptr{a.session}, args...)...)
-	if err == windows.ERROR_SUCCESS {
-		err = nil
+}
+
+// current get session of outstanding packet, the packet hold a reference,
+// so the session can't be ended
+func (a *Adapter) current() (*session, error) {
+	if s := a.session.Load(); s != nil {
+		return s, nil
+	} else if a.closed.Load() {
+		return nil, errors.WithStack(ErrAdapterClosed{})
 	}
-	return r1, r2, errors.WithStack(err)
+	return nil, errors.WithStack(ErrAdapterStoped{})
 }
 
+// Start start session, it wait the session being stopped ended.
 func (a *Adapter) Start(capacity uint32) (err error) {
-	if capacity < MinRingCapacity || MaxRingCapacity < capacity {
-		return errors.New("invalid ring buff capacity")
+	if err := validateCapacity(capacity); err != nil {
+		return err
 	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	for s := a.stopping; s != nil; s = a.stopping {
+		a.mu.Unlock()
+		<-s.drained
+		a.mu.Lock()
+		if err := a.endLocked(s); err != nil {
+			return err
+		}
+	}
+	return a.startLocked(capacity)
+}
 
-	if a.handle == 0 {
+func (a *Adapter) startLocked(capacity uint32) error {
+	if a.handle == 0 || a.closed.Load() {
 		return errors.WithStack(ErrAdapterClosed{})
+	} else if a.session.Load() != nil {
+		return errors.WithStack(errnoError{err: ErrSessionStarted{}, errno: windows.ERROR_ALREADY_INITIALIZED})
 	}
 	fd, _, err := syscall.SyscallN(
 		procStartSession.Addr(),
 		a.handle,
 		uintptr(capacity),
 	)
-	if err != windows.ERROR_SUCCESS {
-		return err
+	if fd == 0 {
+		return errnoErr(err)
 	}
-	a.session = fd
+
+	r0, _, err := syscall.SyscallN(procGetReadWaitEvent.Addr(), fd)
+	if r0 == 0 {
+		syscall.SyscallN(procEndSession.Addr(), fd)
+		return errnoErr(err)
+	}
+	stop, e := windows.CreateEvent(nil, 1, 0, nil)
+	if e != nil {
+		syscall.SyscallN(procEndSession.Addr(), fd)
+		return errors.WithStack(e)
+	}
+
+	a.session.Store(&session{
+		sessionRef: newSessionRef(),
+		handle:     fd,
+		capacity:   capacity,
+		events:     [2]windows.Handle{windows.Handle(r0), stop},
+	})
 	return nil
 }
 
+// Stop end session, it wait all received/allocated packets released, so a
+// not released packet block Stop forever. the wait not hold adapter lock,
+// other methods are not blocked.
 func (a *Adapter) Stop() error {
+	a.mu.Lock()
+	s, err := a.stopLocked()
+	a.mu.Unlock()
+	if s == nil {
+		return err
+	}
+	<-s.drained
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	return a.stopLocked()
+	if e := a.endLocked(s); err == nil {
+		err = e
+	}
+	return err
 }
 
-func (a *Adapter) stopLocked() error {
-	if a.session > 0 {
-		_, _, err := syscall.SyscallN(procEndSession.Addr(), uintptr(a.session))
-		if err != windows.ERROR_SUCCESS {
-			return err
+// stopLocked begin stop session, later Recv/Alloc fail, return the session
+// that must be ended by endLocked after drained, nil if not started. it can
+// be called again when the session is being stopped.
+func (a *Adapter) stopLocked() (*session, error) {
+	s := a.session.Load()
+	if s == nil || a.stopping == s {
+		return s, nil
+	}
+	a.stopping = s
+
+	err := windows.SetEvent(s.events[1])
+	s.shutdown()
+	return s, errors.WithStack(err)
+}
+
+// endLocked end the drained session, noop if it's already ended
+func (a *Adapter) endLocked(s *session) error {
+	if a.stopping == s {
+		a.stopping = nil
+	}
+	if a.session.Load() != s {
+		return nil
+	}
+	a.session.Store(nil)
+	syscall.SyscallN(procEndSession.Addr(), s.handle)
+	return errors.WithStack(windows.CloseHandle(s.events[1]))
+}
+
+// resize restart session with larger capacity, the restart is skipped if
+// outstanding packets not released within timeout
+func (a *Adapter) resize(capacity uint32, timeout time.Duration) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := a.session.Load()
+	if s == nil || s.capacity >= capacity || a.stopping == s {
+		return nil
+	}
+
+	// Recv/Alloc wait restart finished, instead of return ErrAdapterStoped
+	a.restarting.Store(true)
+	defer a.restarting.Store(false)
+
+	if err := windows.SetEvent(s.events[1]); err != nil {
+		return errors.WithStack(err)
+	}
+	select {
+	case <-s.shutdown():
+	case <-time.After(timeout):
+		if s.reopen() {
+			return errors.WithStack(windows.ResetEvent(s.events[1]))
 		}
-		a.session = 0
+		// drained just now
+	}
+	if err := a.endLocked(s); err != nil {
+		return err
+	}
+
+	if err := a.startLocked(capacity); err != nil {
+		if e := a.startLocked(s.capacity); e != nil {
+			return errors.WithMessagef(err, "restart with previous capacity %d: %s", s.capacity, e.Error())
+		}
+		return err
 	}
 	return nil
 }
 
+// Close close adapter, like Stop, it wait all received/allocated packets
+// released without adapter lock.
 func (a *Adapter) Close() error {
+	a.mu.Lock()
+	if a.handle == 0 {
+		a.mu.Unlock()
+		return nil
+	}
+	// reject new route managers, notifiers and session, and stop the attached
+	// without lock, the route managers detach from adapter when Close, and the
+	// MTU notifiers' in-flight callbacks may call adapter methods
+	a.closed.Store(true)
+	routes, notifiers := slices.Clone(a.routes), a.notifiers
+	a.notifiers = nil
+	s, err := a.stopLocked()
+	a.mu.Unlock()
+
+	for _, m := range routes {
+		if e := m.Close(); err == nil {
+			err = e
+		}
+	}
+	for _, n := range notifiers {
+		if e := n.unregister(); err == nil {
+			err = e
+		}
+	}
+	if s != nil {
+		<-s.drained
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	if a.handle == 0 {
+		return err // closed concurrently
+	}
+	if a.nrpt {
+		if e := a.clearNRPT(); err == nil {
+			err = e
+		}
+	}
 
-	if a.handle > 0 {
-		err := a.stopLocked()
-		if err != nil {
-			return err
+	// WintunCloseAdapter always free the handle, so the adapter is closed
+	// even if failed
+	if s != nil {
+		if e := a.endLocked(s); err == nil {
+			err = e
 		}
+	}
+	_, _, e := syscall.SyscallN(procCloseAdapter.Addr(), a.handle)
+	if e := errnoErr(e); err == nil {
+		err = e
+	}
+	a.handle = 0
 
-		_, _, err = syscall.SyscallN(procCloseAdapter.Addr(), a.handle)
-		if err != windows.ERROR_SUCCESS {
-			return err
+	if a.done != nil {
+		close(a.done)
+		a.done = nil
+	}
+	if a.owner != 0 {
+		if e := unmarkOwner(&a.ownerGuid, a.owner); err == nil {
+			err = e
 		}
-		a.handle = 0
+		a.owner = 0
 	}
-	return nil
+	return err
 }
 
 func (a *Adapter) GetAdapterLuid() (winipcfg.LUID, error) {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
+	return a.luidLocked()
+}
 
+func (a *Adapter) luidLocked() (winipcfg.LUID, error) {
 	if a.handle == 0 {
 		return 0, errors.WithStack(ErrAdapterClosed{})
 	}
@@ -109,7 +323,7 @@ func (a *Adapter) GetAdapterLuid() (winipcfg.LUID, error) {
 		a.handle,
 		uintptr(unsafe.Pointer(&luid)),
 	)
-	if err != windows.ERROR_SUCCESS {
+	if err := errnoErr(err); err != nil {
 		return 0, err
 	}
 	return winipcfg.LUID(luid), nil
@@ -123,63 +337,58 @@ func (a *Adapter) Index() (int, error) {
 
 	row, err := luid.Interface()
 	if err != nil {
-		return 0, err
+		return 0, errors.WithStack(err)
 	}
 	return int(row.InterfaceIndex), nil
 }
 
-func (a *Adapter) getReadWaitEvent() (windows.Handle, error) {
-	r0, _, err := a.sessionLocked(procGetReadWaitEvent.Addr())
-	if r0 == 0 {
-		return 0, err
-	}
-	return windows.Handle(r0), nil
-}
-
 type rpack []byte
 
-// Recv receive outbound(income adapter) ip packet, after must call ap.Release(p)
+// Recv receive outbound(income adapter) ip packet, after must call ap.Release(p),
+// Stop and Close will wait until the packet released
 func (a *Adapter) Recv(ctx context.Context) (ip rpack, err error) {
-	a.mu.RLock()
-	defer a.mu.RUnlock()
-
 	var size uint32
 	for {
-		r0, _, err := a.sessionLocked(
+		s, err := a.acquire()
+		if err != nil {
+			return nil, err
+		}
+
+		r0, _, err := syscall.SyscallN(
 			procReceivePacket.Addr(),
+			s.handle,
 			(uintptr)(unsafe.Pointer(&size)),
 		)
-
 		if r0 > 0 {
+			// the reference is hold by packet, released by Release
+			a.stats.recv.Add(1)
 			ptr := unsafe.Add(nil, r0)
 			return unsafe.Slice((*byte)(ptr), size), nil
-		} else {
-			if errors.Is(err, windows.ERROR_NO_MORE_ITEMS) {
-
-				var event uint32
-				if w, err := a.getReadWaitEvent(); err != nil {
-					return nil, errors.WithStack(err)
-				} else {
-					event, err = windows.WaitForSingleObject(w, 100)
-					if err != nil {
-						return nil, errors.WithStack(err)
-					}
-				}
-
-				switch event {
-				case windows.WAIT_OBJECT_0:
-				case uint32(windows.WAIT_TIMEOUT):
-					select {
-					case <-ctx.Done():
-						return nil, errors.WithStack(ctx.Err())
-					default:
-					}
-				default:
-					return nil, errors.Errorf("invalid WaitForSingleObject event %d", event)
-				}
-			} else {
-				return nil, err
+		} else if err != windows.ERROR_NO_MORE_ITEMS && err != windows.ERROR_SUCCESS {
+			s.release()
+			return nil, errnoErr(err)
+		}
+
+		a.stats.recvWait.Add(1)
+		event, err := windows.WaitForMultipleObjects(s.events[:], false, 100)
+		s.release()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		switch event {
+		case windows.WAIT_OBJECT_0:
+		case windows.WAIT_OBJECT_0 + 1:
+			// session ending, retry will get ErrAdapterStoped/ErrAdapterClosed
+			// or the restarted session
+		case uint32(windows.WAIT_TIMEOUT):
+			select {
+			case <-ctx.Done():
+				return nil, errors.WithStack(ctx.Err())
+			default:
 			}
+		default:
+			return nil, errors.Errorf("invalid WaitForMultipleObjects event %d", event)
 		}
 	}
 }
@@ -188,32 +397,47 @@ func (a *Adapter) Release(p rpack) error {
 	if len(p) == 0 {
 		return nil
 	}
-	a.mu.RLock()
-	defer a.mu.RUnlock()
+	s, err := a.current()
+	if err != nil {
+		return err
+	}
+	defer s.release()
 
-	_, _, err := a.sessionLocked(
+	syscall.SyscallN(
 		procReleaseReceivePacket.Addr(),
+		s.handle,
 		uintptr(unsafe.Pointer(&p[0])),
 	)
-	return err
+	return nil
 }
 
 type spack []byte
 
+// Alloc alloc send packet, after must call ap.Send(p), Stop and Close will
+// wait until the packet sent
 func (a *Adapter) Alloc(size int) (spack, error) {
 	if size == 0 {
 		return spack{}, nil
 	}
-	a.mu.RLock()
-	defer a.mu.RUnlock()
+	s, err := a.acquire()
+	if err != nil {
+		return nil, err
+	}
 
-	r0, _, err := a.sessionLocked(
+	r0, _, err := syscall.SyscallN(
 		procAllocateSendPacket.Addr(),
+		s.handle,
 		uintptr(size),
 	)
 	if r0 == 0 {
+		s.release()
+		err = errnoErr(err)
+		if errors.Is(err, ErrRingFull{}) {
+			a.stats.ringFull.Add(1)
+		}
 		return nil, err
 	}
+	a.stats.alloc.Add(1)
 
 	p := (*byte)(unsafe.Add(*new(unsafe.Pointer), r0))
 	return unsafe.Slice(p, size), nil
@@ -224,12 +448,68 @@ func (a *Adapter) Send(ip spack) error {
 	if len(ip) == 0 {
 		return nil
 	}
-	a.mu.RLock()
-	defer a.mu.RUnlock()
+	s, err := a.current()
+	if err != nil {
+		return err
+	}
+	defer s.release()
 
-	_, _, err := a.sessionLocked(
+	syscall.SyscallN(
 		procSendPacket.Addr(),
+		s.handle,
 		uintptr(unsafe.Pointer(&ip[0])),
 	)
-	return err
+	return nil
 }
+
+// Stats get adapter session statistics
+func (a *Adapter) Stats() Stats {
+	return Stats{
+		Recv:     a.stats.recv.Load(),
+		RecvWait: a.stats.recvWait.Load(),
+		Alloc:    a.stats.alloc.Load(),
+		RingFull: a.stats.ringFull.Load(),
+	}
+}
+
+// Capacity get ring capacity of current session, 0 if stopped
+func (a *Adapter) Capacity() uint32 {
+	if s := a.session.Load(); s != nil {
+		return s.capacity
+	}
+	return 0
+}
+
+// adaptive restart session with larger ring when sustained pressure detected
+func (a *Adapter) adaptive(cfg AdaptiveConfig, done <-chan struct{}) {
+	sizer := newRingSizer(cfg)
+	ticker := time.NewTicker(sizer.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		grow, ok := sizer.observe(a.Stats(), a.Capacity())
+		if !ok {
+			continue
+		}
+
+		if err := a.resize(grow, sizer.cfg.Interval); err != nil {
+			sizer.cfg.OnError(err)
+		}
+	}
+}
+
+// Device get adapter as Device
+func (a *Adapter) Device() Device { return adapterDevice{a} }
+
+type adapterDevice struct{ a *Adapter }
+
+func (d adapterDevice) Recv(ctx context.Context) ([]byte, error) { return d.a.Recv(ctx) }
+func (d adapterDevice) Release(ip []byte) error                  { return d.a.Release(ip) }
+func (d adapterDevice) Alloc(size int) ([]byte, error)           { return d.a.Alloc(size) }
+func (d adapterDevice) Send(ip []byte) error                     { return d.a.Send(ip) }
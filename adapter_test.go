@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 package wintun_test
 
 import (
@@ -15,6 +18,8 @@ import (
 	"github.com/lysShub/wintun-go"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -30,7 +35,7 @@ func Test_Invalid_Ring_Capacity(t *testing.T) {
 		require.NoError(t, err)
 
 		err = ap.Start(wintun.MinRingCapacity - 1)
-		require.Error(t, err)
+		require.True(t, errors.Is(err, wintun.ErrInvalidCapacity{}))
 	})
 	t.Run("greater", func(t *testing.T) {
 		ap, err := wintun.CreateAdapter("testinvalidringgreater")
@@ -41,7 +46,8 @@ func Test_Invalid_Ring_Capacity(t *testing.T) {
 		require.NoError(t, err)
 
 		err = ap.Start(wintun.MaxRingCapacity + 1)
-		require.Error(t, err)
+		require.True(t, errors.Is(err, wintun.ErrInvalidCapacity{}))
+		require.True(t, errors.Is(err, windows.ERROR_INVALID_PARAMETER))
 	})
 }
 
@@ -55,6 +61,10 @@ func Test_Adapter_Create(t *testing.T) {
 
 		err = ap.Start(wintun.MinRingCapacity)
 		require.True(t, errors.Is(err, windows.ERROR_ALREADY_INITIALIZED))
+		require.True(t, errors.Is(err, wintun.ErrSessionStarted{}))
+		var te interface{ Temporary() bool }
+		require.True(t, errors.As(err, &te))
+		require.False(t, te.Temporary())
 	})
 	t.Run("create/stop/stop", func(t *testing.T) {
 		ap, err := wintun.CreateAdapter("createstopstop")
@@ -232,6 +242,36 @@ func Test_Recving_Close(t *testing.T) {
 			}
 		}()
 	}
+
+	// session not end until the held packet sent
+	for _, stop := range []func(*wintun.Adapter) error{(*wintun.Adapter).Stop, (*wintun.Adapter).Close} {
+		func() {
+			ap, err := wintun.CreateAdapter("testrecvingclose")
+			require.NoError(t, err)
+			defer ap.Close()
+
+			p, err := ap.Alloc(header.IPv4MinimumSize)
+			require.NoError(t, err)
+
+			stopped := make(chan error, 1)
+			go func() { stopped <- stop(ap) }()
+			select {
+			case <-stopped:
+				t.Fatal("session ended with held packet")
+			case <-time.After(time.Millisecond * 200):
+			}
+
+			_, err = ap.Alloc(header.IPv4MinimumSize)
+			require.Error(t, err)
+
+			// the wait not hold adapter lock
+			_, err = ap.GetAdapterLuid()
+			require.NoError(t, err)
+
+			require.NoError(t, ap.Send(p))
+			require.NoError(t, <-stopped)
+		}()
+	}
 }
 
 func Test_Echo_UDP_Adapter(t *testing.T) {
@@ -388,3 +428,355 @@ func Test_Session_Restart(t *testing.T) {
 func Test_Auto_Handle_DF(t *testing.T) {
 	t.Skip("todo")
 }
+
+func Test_Adapters(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	name := "testadapters"
+	ap, err := wintun.CreateAdapter(name, wintun.TunType("testadapters"))
+	require.NoError(t, err)
+	defer ap.Close()
+
+	luid, err := ap.GetAdapterLuid()
+	require.NoError(t, err)
+
+	t.Run("list", func(t *testing.T) {
+		infos, err := wintun.Adapters("testadapters")
+		require.NoError(t, err)
+		require.Equal(t, 1, len(infos))
+		require.Equal(t, name, infos[0].Name)
+		require.Equal(t, "testadapters", infos[0].TunType)
+		require.Equal(t, luid, infos[0].Luid)
+
+		infos, err = wintun.Adapters("testadaptersnotexist")
+		require.NoError(t, err)
+		require.Empty(t, infos)
+	})
+
+	t.Run("open-luid", func(t *testing.T) {
+		b, err := wintun.OpenAdapterByLuid(luid, wintun.AutoStart(false))
+		require.NoError(t, err)
+		defer b.Close()
+	})
+
+	t.Run("open-guid", func(t *testing.T) {
+		guid, err := luid.GUID()
+		require.NoError(t, err)
+
+		b, err := wintun.OpenAdapterByGuid(guid, wintun.AutoStart(false))
+		require.NoError(t, err)
+		defer b.Close()
+	})
+}
+
+func Test_CleanAdapters(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testcleanadapters", wintun.TunType("testcleanadapters"))
+	require.NoError(t, err)
+	defer ap.Close()
+
+	t.Run("owned", func(t *testing.T) {
+		deleted, err := wintun.CleanAdapters("testcleanadapters", nil)
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+
+		infos, err := wintun.Adapters("testcleanadapters")
+		require.NoError(t, err)
+		require.Equal(t, 1, len(infos))
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		deleted, err := wintun.CleanAdapters("testcleanadapters", wintun.NamePrefix("notmatch"))
+		require.NoError(t, err)
+		require.Empty(t, deleted)
+	})
+
+	t.Run("marker", func(t *testing.T) {
+		b, err := wintun.CreateAdapter("testcleanadaptersmarker", wintun.TunType("testcleanadapters"))
+		require.NoError(t, err)
+		luid, err := b.GetAdapterLuid()
+		require.NoError(t, err)
+		guid, err := luid.GUID()
+		require.NoError(t, err)
+
+		marker := `SOFTWARE\wintun-go\Adapters\` + guid.String()
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, marker, registry.QUERY_VALUE)
+		require.NoError(t, err)
+		key.Close()
+
+		require.NoError(t, b.Close())
+		_, err = registry.OpenKey(registry.LOCAL_MACHINE, marker, registry.QUERY_VALUE)
+		require.True(t, errors.Is(err, registry.ErrNotExist))
+	})
+}
+
+func Test_Adapter_DerivedGuid(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	name := "testderivedguid"
+	ns := wintun.MustParseGuid("{0E7B53F8-4E48-4C2F-8A9B-2A8E1C4A7F10}")
+
+	ap, err := wintun.CreateAdapter(name, wintun.DerivedGuid(ns))
+	require.NoError(t, err)
+	defer ap.Close()
+
+	luid, err := ap.GetAdapterLuid()
+	require.NoError(t, err)
+	guid, err := luid.GUID()
+	require.NoError(t, err)
+	require.Equal(t, wintun.DeriveGuid(ns, name).String(), guid.String())
+}
+
+func Test_Adapter_Create_Invalid(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	t.Run("ring", func(t *testing.T) {
+		ap, err := wintun.CreateAdapter("testcreateinvalidring", wintun.RingBuff(wintun.MinRingCapacity*3))
+		require.True(t, errors.Is(err, wintun.ErrInvalidCapacity{}))
+		require.Nil(t, ap)
+	})
+
+	t.Run("name", func(t *testing.T) {
+		ap, err := wintun.CreateAdapter(strings.Repeat("a", wintun.MaxAdapterName))
+		require.Error(t, err)
+		require.Nil(t, ap)
+	})
+
+	t.Run("tunnel-type", func(t *testing.T) {
+		ap, err := wintun.CreateAdapter("testcreateinvalidtuntype", wintun.TunType(""))
+		require.Error(t, err)
+		require.Nil(t, ap)
+	})
+}
+
+func Test_Adapter_AutoRingBuff(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testautoringbuff",
+		wintun.AutoRingBuff(100<<20, time.Millisecond*20, 1500),
+		wintun.AdaptiveRing(wintun.AdaptiveConfig{}),
+	)
+	require.NoError(t, err)
+	defer ap.Close()
+
+	require.Equal(t, wintun.RingCapacity(100<<20, time.Millisecond*20, 1500), ap.Capacity())
+}
+
+func Test_Adapter_AutoStart(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	name := "testadapterautostart"
+	ap, err := wintun.CreateAdapter(name, wintun.AutoStart(false))
+	require.NoError(t, err)
+	defer ap.Close()
+
+	_, err = ap.Alloc(64)
+	require.True(t, errors.Is(err, wintun.ErrAdapterStoped{}))
+
+	t.Run("open", func(t *testing.T) {
+		b, err := wintun.OpenAdapter(name, wintun.RingBuff(wintun.MinRingCapacity*4))
+		require.NoError(t, err)
+		defer b.Close()
+
+		require.Equal(t, uint32(wintun.MinRingCapacity*4), b.Capacity())
+	})
+
+	t.Run("open-invalid-ring", func(t *testing.T) {
+		b, err := wintun.OpenAdapter(name, wintun.RingBuff(wintun.MinRingCapacity-1))
+		require.True(t, errors.Is(err, wintun.ErrInvalidCapacity{}))
+		require.Nil(t, b)
+	})
+}
+
+func Test_Adapter_RouteManager(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testadapterroutemanager")
+	require.NoError(t, err)
+	defer ap.Close()
+	luid, err := ap.GetAdapterLuid()
+	require.NoError(t, err)
+	err = luid.SetIPAddresses([]netip.Prefix{netip.MustParsePrefix("10.0.9.3/24")})
+	require.NoError(t, err)
+
+	m, err := ap.RouteManager(wintun.RouteConfig{Metric: 5})
+	require.NoError(t, err)
+
+	var dst = netip.MustParsePrefix("10.9.8.0/24")
+	require.NoError(t, m.Add(dst))
+	_, err = luid.Route(dst, netip.IPv4Unspecified())
+	require.NoError(t, err)
+	require.Len(t, m.Routes(), 1)
+
+	require.NoError(t, m.Set(nil))
+	_, err = luid.Route(dst, netip.IPv4Unspecified())
+	require.Error(t, err)
+	require.Empty(t, m.Routes())
+
+	require.NoError(t, m.Add(dst))
+	require.NoError(t, ap.Close())
+	require.Empty(t, m.Routes())
+	require.True(t, errors.Is(m.Add(dst), wintun.ErrRouteManagerClosed{}))
+}
+
+func Test_Adapter_RouteManager_Bypass(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testadapterroutebypass")
+	require.NoError(t, err)
+	defer ap.Close()
+	luid, err := ap.GetAdapterLuid()
+	require.NoError(t, err)
+	err = luid.SetIPAddresses([]netip.Prefix{netip.MustParsePrefix("10.0.9.3/24")})
+	require.NoError(t, err)
+
+	m, err := ap.RouteManager(wintun.RouteConfig{Metric: 5})
+	require.NoError(t, err)
+
+	// endpoint bypass after default overridden, still route by physical interface
+	var ep = netip.MustParseAddr("1.1.1.1")
+	require.NoError(t, m.OverrideDefault(true, false))
+	if err := m.Bypass(ep); err != nil {
+		t.Skip("no physical route", err)
+	}
+
+	rows, err := winipcfg.GetIPForwardTable2(windows.AF_INET)
+	require.NoError(t, err)
+	var found bool
+	for _, row := range rows {
+		if row.DestinationPrefix.Prefix() == netip.PrefixFrom(ep, 32) {
+			require.NotEqual(t, luid, row.InterfaceLUID)
+			found = true
+		}
+	}
+	require.True(t, found)
+	require.Len(t, m.Routes(), len(wintun.DefaultRoutes(true, false))+1)
+
+	require.NoError(t, m.Close())
+	rows, err = winipcfg.GetIPForwardTable2(windows.AF_INET)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NotEqual(t, netip.PrefixFrom(ep, 32), row.DestinationPrefix.Prefix())
+	}
+}
+
+func Test_Adapter_DNS(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testadapterdns")
+	require.NoError(t, err)
+	defer ap.Close()
+	luid, err := ap.GetAdapterLuid()
+	require.NoError(t, err)
+
+	var servers = []netip.Addr{netip.MustParseAddr("10.0.9.53"), netip.MustParseAddr("fd00::53")}
+	require.NoError(t, ap.SetDNS(servers, []string{"lab.local"}))
+	dns, err := luid.DNS()
+	require.NoError(t, err)
+	require.ElementsMatch(t, servers, dns)
+	require.Error(t, ap.SetDNS([]netip.Addr{netip.IPv4Unspecified()}, nil))
+
+	var rules = []wintun.NRPTRule{{Namespaces: []string{"lab.local"}, Servers: servers[:1]}}
+	require.NoError(t, ap.SetNRPT(rules))
+	got, err := ap.NRPT()
+	require.NoError(t, err)
+	require.Equal(t, rules, got)
+
+	// registry enumerate "-10" before "-2"
+	rules = rules[:0]
+	for i := 0; i < 12; i++ {
+		rules = append(rules, wintun.NRPTRule{Namespaces: []string{fmt.Sprintf("n%d.lab.local", i)}, Servers: servers[:1]})
+	}
+	require.NoError(t, ap.SetNRPT(rules))
+	got, err = ap.NRPT()
+	require.NoError(t, err)
+	require.Equal(t, rules, got)
+
+	require.NoError(t, ap.SetNRPT(nil))
+	got, err = ap.NRPT()
+	require.NoError(t, err)
+	require.Empty(t, got)
+}
+
+func Test_Adapter_MTU(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testadaptermtu")
+	require.NoError(t, err)
+	defer ap.Close()
+
+	changes := make(chan wintun.MTUChange, 8)
+	cancel, err := ap.NotifyMTU(func(c wintun.MTUChange) { changes <- c })
+	require.NoError(t, err)
+	defer cancel()
+
+	require.NoError(t, ap.SetMTU(1400, 1380))
+	v4, v6, err := ap.MTU()
+	require.NoError(t, err)
+	require.Equal(t, 1400, v4)
+	require.Equal(t, 1380, v6)
+
+	err = ap.SetMTU(wintun.MinMTU4-1, 0)
+	require.True(t, errors.Is(err, windows.ERROR_INVALID_PARAMETER))
+	err = ap.SetMTU(0, wintun.MinMTU6-1)
+	require.True(t, errors.Is(err, windows.ERROR_INVALID_PARAMETER))
+	var e wintun.ErrInvalidMTU
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, wintun.ErrInvalidMTU{IPv6: true, MTU: wintun.MinMTU6 - 1}, e)
+
+	// external change
+	idx, err := ap.Index()
+	require.NoError(t, err)
+	out, err := exec.Command("netsh", "interface", "ipv4", "set", "subinterface",
+		strconv.Itoa(idx), "mtu=1300", "store=active").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	select {
+	case c := <-changes:
+		require.Equal(t, wintun.MTUChange{Old: 1400, New: 1300}, c)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	require.Empty(t, changes)
+}
+
+func Test_Adapter_MTU_Close(t *testing.T) {
+	wintun.MustLoad(wintun.DLL)
+
+	ap, err := wintun.CreateAdapter("testadaptermtuclose")
+	require.NoError(t, err)
+	defer ap.Close()
+
+	// callback call adapter method while adapter Close
+	entered := make(chan struct{}, 8)
+	_, err = ap.NotifyMTU(func(c wintun.MTUChange) {
+		entered <- struct{}{}
+		time.Sleep(time.Millisecond * 200)
+		ap.MTU()
+	})
+	require.NoError(t, err)
+
+	idx, err := ap.Index()
+	require.NoError(t, err)
+	out, err := exec.Command("netsh", "interface", "ipv4", "set", "subinterface",
+		strconv.Itoa(idx), "mtu=1300", "store=active").CombinedOutput()
+	require.NoError(t, err, string(out))
+	select {
+	case <-entered:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- ap.Close() }()
+	select {
+	case err := <-closed:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Close deadlock with in-flight callback")
+	}
+
+	_, err = ap.NotifyMTU(func(wintun.MTUChange) {})
+	require.True(t, errors.Is(err, wintun.ErrAdapterClosed{}))
+}
@@ -0,0 +1,210 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	stderrors "errors"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// the adapter created by CreateAdapter hold a named mutex as ownership marker,
+// the mutex will be destroyed by system when owner process exit.
+func ownerName(guid *windows.GUID) string {
+	return `Global\wintun-go-` + guid.String()
+}
+
+// registry key of persistent markers, every adapter created by CreateAdapter
+// has a subkey named by its GUID until Close, only the marked adapters can be
+// reaped by CleanAdapters.
+const markerKey = `SOFTWARE\wintun-go\Adapters`
+
+func markerName(guid *windows.GUID) string {
+	return markerKey + `\` + guid.String()
+}
+
+// markOwner mark adapter guid as created by this process, it must be called
+// before adapter created, so that the adapter can't be regarded as stale by
+// CleanAdapters of other process in the meantime.
+func markOwner(guid *windows.GUID) (windows.Handle, error) {
+	name16, err := windows.UTF16PtrFromString(ownerName(guid))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	h, err := windows.CreateMutex(nil, false, name16)
+	if err != nil && err != windows.ERROR_ALREADY_EXISTS {
+		return 0, errors.WithStack(err)
+	}
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, markerName(guid), registry.QUERY_VALUE)
+	if err != nil {
+		windows.CloseHandle(h)
+		return 0, errors.WithStack(err)
+	}
+	return h, errors.WithStack(key.Close())
+}
+
+// unmarkOwner remove markers of adapter guid, h is returned by markOwner
+func unmarkOwner(guid *windows.GUID, h windows.Handle) error {
+	err := deleteMarker(guid)
+	if e := windows.CloseHandle(h); err == nil {
+		err = errors.WithStack(e)
+	}
+	return err
+}
+
+func deleteMarker(guid *windows.GUID) error {
+	err := registry.DeleteKey(registry.LOCAL_MACHINE, markerName(guid))
+	if err == registry.ErrNotExist {
+		return nil
+	}
+	return errors.WithStack(err)
+}
+
+// marked the adapter is created by CreateAdapter and not closed
+func marked(guid *windows.GUID) (bool, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, markerName(guid), registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, errors.WithStack(err)
+	}
+	return true, errors.WithStack(key.Close())
+}
+
+// owned the adapter is owned by a live process
+func owned(guid *windows.GUID) (bool, error) {
+	name16, err := windows.UTF16PtrFromString(ownerName(guid))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	h, err := windows.OpenMutex(windows.SYNCHRONIZE, false, name16)
+	if err == nil {
+		return true, errors.WithStack(windows.CloseHandle(h))
+	} else if err == windows.ERROR_FILE_NOT_FOUND {
+		return false, nil
+	}
+	return false, errors.WithStack(err)
+}
+
+// NamePrefix reap policy, only reap adapter that name has prefix
+func NamePrefix(prefix string) func(info AdapterInfo) bool {
+	return func(info AdapterInfo) bool {
+		return strings.HasPrefix(info.Name, prefix)
+	}
+}
+
+// CleanAdapters delete stale adapters that created by CreateAdapter but not
+// owned by a live process, tunType filter adapters like Adapters, reap decide
+// whether delete the stale adapter, nil means delete all. return the deleted
+// adapters, the failed adapters are skipped and their errors are joined.
+//
+// adapter created by CreateAdapter is owned by creator process until Close,
+// the adapters created by other way, such as other wintun users, are never
+// deleted. the NRPT rules set by SetNRPT of deleted adapter are also removed.
+func CleanAdapters(tunType string, reap func(info AdapterInfo) bool) ([]AdapterInfo, error) {
+	infos, err := Adapters(tunType)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		deleted []AdapterInfo
+		errs    []error
+	)
+	for _, e := range infos {
+		if ok, err := marked(&e.Guid); err != nil {
+			errs = append(errs, err)
+			continue
+		} else if !ok {
+			continue
+		}
+		if ok, err := owned(&e.Guid); err != nil {
+			errs = append(errs, err)
+			continue
+		} else if ok {
+			continue
+		}
+		if reap != nil && !reap(e) {
+			continue
+		}
+
+		// the adapter removed after enumerated is regarded as deleted, but
+		// its markers still need to be removed
+		err := deleteAdapter(&e.Guid)
+		gone := errors.Is(err, ErrAdapterNotFound{})
+		if err != nil && !gone {
+			errs = append(errs, err)
+			continue
+		}
+		// the NRPT rules left by owner process
+		if err := setNRPT(GUID(e.Guid), nil); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := deleteMarker(&e.Guid); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !gone {
+			deleted = append(deleted, e)
+		}
+	}
+	return deleted, stderrors.Join(errs...)
+}
+
+var deviceClassNet = &windows.GUID{
+	Data1: 0x4d36e972, Data2: 0xe325, Data3: 0x11ce,
+	Data4: [8]byte{0xbf, 0xc1, 0x08, 0x00, 0x2b, 0xe1, 0x03, 0x18},
+}
+
+// deleteAdapter remove the network device of adapter
+func deleteAdapter(guid *windows.GUID) error {
+	devs, err := windows.SetupDiGetClassDevsEx(deviceClassNet, "", 0, windows.DIGCF_PRESENT, 0, "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer devs.Close()
+
+	for i := 0; ; i++ {
+		data, err := devs.EnumDeviceInfo(i)
+		if err == windows.ERROR_NO_MORE_ITEMS {
+			return errors.WithStack(errnoError{err: ErrAdapterNotFound{}, errno: windows.ERROR_NOT_FOUND})
+		} else if err != nil {
+			continue
+		}
+
+		id, err := netCfgInstanceId(devs, data)
+		if err != nil || !strings.EqualFold(id, guid.String()) {
+			continue
+		}
+
+		params := windows.RemoveDeviceParams{
+			ClassInstallHeader: *windows.MakeClassInstallHeader(windows.DIF_REMOVE),
+			Scope:              windows.DI_REMOVEDEVICE_GLOBAL,
+		}
+		err = devs.SetClassInstallParams(data, &params.ClassInstallHeader, uint32(unsafe.Sizeof(params)))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errnoErr(devs.CallClassInstaller(windows.DIF_REMOVE, data))
+	}
+}
+
+func netCfgInstanceId(devs windows.DevInfo, data *windows.DevInfoData) (string, error) {
+	h, err := devs.OpenDevRegKey(data, windows.DICS_FLAG_GLOBAL, 0, windows.DIREG_DRV, windows.KEY_QUERY_VALUE)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	key := registry.Key(h)
+	defer key.Close()
+
+	id, _, err := key.GetStringValue("NetCfgInstanceId")
+	return id, errors.WithStack(err)
+}
@@ -1,6 +1,3 @@
-//go:build windows
-// +build windows
-
 package wintun
 
 const (
@@ -10,3 +7,23 @@ const (
 	// maximum ring capacity
 	MaxRingCapacity = 0x4000000 /* 64MiB */
 )
+
+const (
+	// maximum adapter name and tunnel type length in UTF-16 code
+	// units, include the terminating null character
+	MaxAdapterName = 128
+
+	// default tunnel type of CreateAdapter
+	DefaultTunType = "Wintun"
+)
+
+const (
+	// minimum IPv4 MTU
+	MinMTU4 = 576
+
+	// minimum IPv6 MTU
+	MinMTU6 = 1280
+
+	// maximum MTU, limited by ip packet length
+	MaxMTU = 0xffff
+)
@@ -0,0 +1,45 @@
+package wintun
+
+import (
+	"context"
+
+	"github.com/lysShub/wintun-go/packet"
+)
+
+// Device ip packet device, Adapter can be used as Device by Adapter.Device,
+// all methods can be called concurrently.
+type Device interface {
+	// Recv receive outbound(income adapter) ip packet, after must call Release
+	Recv(ctx context.Context) (ip []byte, err error)
+	Release(ip []byte) error
+
+	// Alloc alloc inbound(outgoing adapter) ip packet, after must call Send
+	Alloc(size int) (ip []byte, err error)
+	Send(ip []byte) error
+}
+
+// WritePacket copy ip to packet allocated by dev, and send it
+func WritePacket(dev Device, ip []byte) error {
+	p, err := dev.Alloc(len(ip))
+	if err != nil {
+		return err
+	}
+	copy(p, ip)
+	return dev.Send(p)
+}
+
+// Inject build ip packet into packet allocated by dev, and send it
+func Inject(dev Device, h packet.IP, t packet.Transport) error {
+	n, err := packet.Size(h, t)
+	if err != nil {
+		return err
+	}
+	p, err := dev.Alloc(n)
+	if err != nil {
+		return err
+	}
+	if _, err = packet.Build(p, h, t); err != nil {
+		return err
+	}
+	return dev.Send(p)
+}
@@ -0,0 +1,108 @@
+package wintun
+
+import (
+	"context"
+	"math/rand"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// memDevice in-memory Device, the packets written to in can be received,
+// and the sent packets can be read from out
+type memDevice struct {
+	in  chan []byte
+	out chan []byte
+
+	recved, released atomic.Int64
+}
+
+var _ Device = (*memDevice)(nil)
+
+func newMemDevice(size int) *memDevice {
+	return &memDevice{
+		in:  make(chan []byte, size),
+		out: make(chan []byte, size),
+	}
+}
+
+func (d *memDevice) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case ip, ok := <-d.in:
+		if !ok {
+			return nil, errors.WithStack(ErrAdapterClosed{})
+		}
+		d.recved.Add(1)
+		return ip, nil
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (d *memDevice) Release(ip []byte) error {
+	d.released.Add(1)
+	return nil
+}
+
+func (d *memDevice) Alloc(size int) ([]byte, error) { return make([]byte, size), nil }
+
+func (d *memDevice) Send(ip []byte) error {
+	d.out <- ip
+	return nil
+}
+
+// outstanding received but not released packets
+func (d *memDevice) outstanding() int64 {
+	return d.recved.Load() - d.released.Load()
+}
+
+func buildUDP(src, dst netip.AddrPort, payload []byte) []byte {
+	var p = make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize+len(payload))
+	iphdr := header.IPv4(p)
+	iphdr.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(p)),
+		ID:          uint16(rand.Uint32()),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.AddrFrom4(src.Addr().As4()),
+		DstAddr:     tcpip.AddrFrom4(dst.Addr().As4()),
+	})
+	iphdr.SetChecksum(^iphdr.CalculateChecksum())
+
+	udphdr := header.UDP(iphdr.Payload())
+	udphdr.Encode(&header.UDPFields{
+		SrcPort: src.Port(),
+		DstPort: dst.Port(),
+		Length:  uint16(header.UDPMinimumSize + len(payload)),
+	})
+	copy(udphdr.Payload(), payload)
+	return p
+}
+
+func Test_Inject(t *testing.T) {
+	dev := newMemDevice(1)
+
+	hdr := packet.IP{
+		Src: netip.MustParseAddr("10.0.0.1"),
+		Dst: netip.MustParseAddr("10.0.0.2"),
+	}
+	err := Inject(dev, hdr, &packet.UDPSegment{SrcPort: 1, DstPort: 2, Payload: []byte("hello")})
+	require.NoError(t, err)
+
+	ip := <-dev.out
+	info, err := packet.Parse(ip)
+	require.NoError(t, err)
+	require.Equal(t, hdr.Dst, info.Dst)
+	require.Equal(t, "hello", string(info.Payload(ip)))
+
+	hdr.Dst = netip.MustParseAddr("fd00::1")
+	err = Inject(dev, hdr, &packet.Echo{})
+	require.ErrorIs(t, err, packet.ErrInvalidAddr{})
+	require.Zero(t, len(dev.out))
+}
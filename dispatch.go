@@ -0,0 +1,125 @@
+package wintun
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/lysShub/wintun-go/packet"
+)
+
+// Handler handle received ip packet, ip will be released after return, the
+// reply packet can be sent by dev.
+type Handler func(dev Device, ip []byte)
+
+type DispatchConfig struct {
+	// worker goroutines, default runtime.NumCPU()
+	Workers int
+
+	// queue size of every worker, receiving will be blocked when the queue is
+	// full, then the packets will be dropped by driver if ring is full. default 64
+	Queue int
+}
+
+func (c *DispatchConfig) init() {
+	if c.Workers <= 0 {
+		c.Workers = runtime.NumCPU()
+	}
+	if c.Queue <= 0 {
+		c.Queue = 64
+	}
+}
+
+// Dispatch receive packets from dev and dispatch them to workers by 5-tuple hash,
+// so the packets of the same flow (both directions) are handled in order by the
+// same worker. It blocks until ctx done or dev Recv failed, and returns after all
+// received packets released, return nil if ctx done.
+func Dispatch(ctx context.Context, dev Device, handler Handler, cfg DispatchConfig) error {
+	cfg.init()
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		retErr error
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		if retErr == nil {
+			retErr = err
+		}
+		mu.Unlock()
+	}
+
+	var queues = make([]chan []byte, cfg.Workers)
+	for i := range queues {
+		queues[i] = make(chan []byte, cfg.Queue)
+
+		wg.Add(1)
+		go func(q <-chan []byte) {
+			defer wg.Done()
+			for ip := range q {
+				// after ctx done, only release the queued packets
+				if ctx.Err() == nil {
+					handler(dev, ip)
+				}
+				if err := dev.Release(ip); err != nil {
+					setErr(err)
+				}
+			}
+		}(queues[i])
+	}
+
+recv:
+	for {
+		ip, err := dev.Recv(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				setErr(err)
+			}
+			break
+		}
+
+		q := queues[flowHash(ip)%uint32(len(queues))]
+		select {
+		case q <- ip:
+		case <-ctx.Done():
+			if err := dev.Release(ip); err != nil {
+				setErr(err)
+			}
+			break recv
+		}
+	}
+
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+	return retErr
+}
+
+// flowHash symmetric hash of ip packet 5-tuple, the fragmented packet only
+// hash addresses and protocol
+func flowHash(ip []byte) uint32 {
+	info, err := packet.Parse(ip)
+	if err != nil {
+		return 0
+	}
+
+	var sport, dport uint16
+	if !info.Fragmented && (info.Proto == packet.TCP || info.Proto == packet.UDP) {
+		sport, dport = info.SrcPort, info.DstPort
+	}
+	src, dst := info.Src.As16(), info.Dst.As16()
+	return fnv32(src[:], sport) ^ fnv32(dst[:], dport) ^ uint32(info.Proto)
+}
+
+func fnv32(addr []byte, port uint16) uint32 {
+	const prime = 16777619
+	var h uint32 = 2166136261
+	for _, b := range addr {
+		h = (h ^ uint32(b)) * prime
+	}
+	h = (h ^ uint32(port>>8)) * prime
+	h = (h ^ uint32(port&0xff)) * prime
+	return h
+}
@@ -0,0 +1,143 @@
+package wintun
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FlowHash(t *testing.T) {
+	var (
+		a = netip.MustParseAddrPort("10.0.0.1:1234")
+		b = netip.MustParseAddrPort("10.0.0.2:80")
+		c = netip.MustParseAddrPort("10.0.0.2:81")
+	)
+
+	require.Equal(t,
+		flowHash(buildUDP(a, b, []byte("a"))),
+		flowHash(buildUDP(a, b, []byte("b"))),
+	)
+	require.Equal(t,
+		flowHash(buildUDP(a, b, nil)),
+		flowHash(buildUDP(b, a, nil)),
+	)
+	require.NotEqual(t,
+		flowHash(buildUDP(a, b, nil)),
+		flowHash(buildUDP(a, c, nil)),
+	)
+
+	require.Zero(t, flowHash(nil))
+	require.Zero(t, flowHash([]byte{0x45, 0}))
+}
+
+func Test_Dispatch(t *testing.T) {
+	t.Run("order", func(t *testing.T) {
+		const flows, packets = 16, 64
+		dev := newMemDevice(flows * packets)
+
+		for i := 0; i < packets; i++ {
+			for f := 0; f < flows; f++ {
+				src := netip.AddrPortFrom(netip.MustParseAddr("10.0.0.1"), uint16(1000+f))
+				dst := netip.MustParseAddrPort("10.0.0.2:80")
+				dev.in <- buildUDP(src, dst, []byte{byte(f), byte(i)})
+			}
+		}
+
+		var (
+			mu   sync.Mutex
+			seqs = map[byte][]byte{}
+		)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			for dev.recved.Load() < flows*packets || dev.outstanding() > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+		}()
+
+		err := Dispatch(ctx, dev, func(dev Device, ip []byte) {
+			payload := ip[28:]
+			mu.Lock()
+			seqs[payload[0]] = append(seqs[payload[0]], payload[1])
+			mu.Unlock()
+		}, DispatchConfig{Workers: 4})
+		require.NoError(t, err)
+
+		require.Equal(t, flows, len(seqs))
+		for _, seq := range seqs {
+			require.Equal(t, packets, len(seq))
+			for i := range seq {
+				require.Equal(t, byte(i), seq[i])
+			}
+		}
+		require.Zero(t, dev.outstanding())
+	})
+
+	t.Run("reply", func(t *testing.T) {
+		dev := newMemDevice(8)
+		dev.in <- buildUDP(
+			netip.MustParseAddrPort("10.0.0.1:1234"),
+			netip.MustParseAddrPort("10.0.0.2:80"),
+			[]byte("hello"),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-dev.out
+			cancel()
+		}()
+
+		var errs = make(chan error, 1)
+		err := Dispatch(ctx, dev, func(dev Device, ip []byte) {
+			errs <- WritePacket(dev, ip)
+		}, DispatchConfig{})
+		require.NoError(t, err)
+		require.NoError(t, <-errs)
+		require.Zero(t, dev.outstanding())
+	})
+
+	t.Run("backpressure", func(t *testing.T) {
+		const workers, queue = 2, 2
+		dev := newMemDevice(64)
+		for i := 0; i < 64; i++ {
+			dev.in <- buildUDP(
+				netip.MustParseAddrPort("10.0.0.1:1234"),
+				netip.MustParseAddrPort("10.0.0.2:80"),
+				nil,
+			)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var (
+			block       = make(chan struct{})
+			outstanding = make(chan int64, 1)
+		)
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			outstanding <- dev.outstanding()
+			cancel()
+			close(block)
+		}()
+
+		err := Dispatch(ctx, dev, func(dev Device, ip []byte) {
+			<-block
+		}, DispatchConfig{Workers: workers, Queue: queue})
+		require.NoError(t, err)
+		// blocked worker hold one, queue hold queue, reader hold one
+		require.LessOrEqual(t, <-outstanding, int64(1+queue+1))
+		require.Zero(t, dev.outstanding())
+	})
+
+	t.Run("recv-error", func(t *testing.T) {
+		dev := newMemDevice(1)
+		close(dev.in)
+
+		err := Dispatch(context.Background(), dev, func(Device, []byte) {}, DispatchConfig{})
+		require.ErrorIs(t, err, ErrAdapterClosed{})
+	})
+}
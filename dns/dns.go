@@ -0,0 +1,268 @@
+// Package dns intercept dns queries received from wintun adapter, answer
+// them by Resolver and inject the responses back to adapter.
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const Port = 53
+
+// maximum udp response size without EDNS
+const maxUDPSize = 512
+
+// udp payload size of EDNS advertised by interceptor
+const ednsSize = 4096
+
+type Config struct {
+	// Servers the queries to servers will be intercepted, empty means all
+	// queries to port 53
+	Servers []netip.Addr
+
+	Resolver Resolver
+
+	// Timeout resolve timeout, also the idle timeout of tcp connection,
+	// default 5s
+	Timeout time.Duration
+
+	// MaxQueries maximum in-flight queries, the exceeded queries are dropped
+	// and counted by Dropped, default 256
+	MaxQueries int
+}
+
+func (c *Config) init() error {
+	if c.Resolver == nil {
+		return errors.New("require resolver")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second * 5
+	}
+	if c.MaxQueries <= 0 {
+		c.MaxQueries = 256
+	}
+	return nil
+}
+
+type ErrClosed struct{}
+
+func (ErrClosed) Error() string { return "interceptor closed" }
+
+// ErrBusy in-flight queries exceed Config.MaxQueries, the query is dropped
+type ErrBusy struct{}
+
+func (ErrBusy) Error() string   { return "interceptor busy" }
+func (ErrBusy) Temporary() bool { return true }
+
+// Interceptor answer udp/tcp dns queries in packets received from adapter,
+// the queries are resolved asynchronously, and the responses are sent by the
+// Device that query received from.
+type Interceptor struct {
+	cfg Config
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	sem     chan struct{}
+	dropped atomic.Uint64
+
+	mu    sync.Mutex
+	conns map[connKey]*tcpConn
+	sweep time.Time
+}
+
+func New(cfg Config) (*Interceptor, error) {
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+
+	var i = &Interceptor{
+		cfg:   cfg,
+		sem:   make(chan struct{}, cfg.MaxQueries),
+		conns: map[connKey]*tcpConn{},
+		sweep: time.Now(),
+	}
+	i.ctx, i.cancel = context.WithCancel(context.Background())
+	return i, nil
+}
+
+func (i *Interceptor) match(info *packet.Info) bool {
+	if info.Fragmented || info.DstPort != Port ||
+		(info.Proto != packet.UDP && info.Proto != packet.TCP) {
+		return false
+	}
+	return len(i.cfg.Servers) == 0 || slices.Contains(i.cfg.Servers, info.Dst.Unmap())
+}
+
+// Intercept intercept ip if it is dns query to configured servers, handled
+// means ip is owned by interceptor, the ip still need be released by caller.
+func (i *Interceptor) Intercept(dev wintun.Device, ip []byte) (handled bool, err error) {
+	info, err := packet.Parse(ip)
+	if err != nil || !i.match(&info) {
+		return false, nil
+	}
+
+	if info.Proto == packet.UDP {
+		return true, i.goServe(&info, slices.Clone(info.Payload(ip)), func(resp []byte) error {
+			return wintun.Inject(dev,
+				packet.IP{Src: info.Dst, Dst: info.Src},
+				&packet.UDPSegment{SrcPort: info.DstPort, DstPort: info.SrcPort, Payload: resp},
+			)
+		})
+	}
+	return true, i.tcp(dev, &info, ip)
+}
+
+// Handler wrap next handler, the dns queries are answered by interceptor,
+// others are passed to next, next can be nil.
+func (i *Interceptor) Handler(next wintun.Handler) wintun.Handler {
+	return func(dev wintun.Device, ip []byte) {
+		if handled, _ := i.Intercept(dev, ip); !handled && next != nil {
+			next(dev, ip)
+		}
+	}
+}
+
+// Dropped queries count that dropped by exceed Config.MaxQueries
+func (i *Interceptor) Dropped() uint64 { return i.dropped.Load() }
+
+// Close stop intercepting and tcp connections, wait for the in-flight queries
+// finished
+func (i *Interceptor) Close() error {
+	i.mu.Lock()
+	i.cancel()
+	conns := i.conns
+	i.conns = map[connKey]*tcpConn{}
+	i.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+	i.wg.Wait()
+	return nil
+}
+
+// goServe resolve query asynchronously, the response is sent by send
+func (i *Interceptor) goServe(info *packet.Info, query []byte, send func(resp []byte) error) error {
+	limit := 0xffff
+	if info.Proto == packet.UDP {
+		limit = maxUDPSize
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.ctx.Err() != nil {
+		return errors.WithStack(ErrClosed{})
+	}
+	select {
+	case i.sem <- struct{}{}:
+	default:
+		i.dropped.Add(1)
+		return errors.WithStack(ErrBusy{})
+	}
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+
+		resp, ok := i.serve(query, limit)
+		<-i.sem
+		if ok {
+			send(resp)
+		}
+	}()
+	return nil
+}
+
+// serve resolve query message and build response, response size not exceed
+// limit, if exceed, the truncated response returned. not ok means the query
+// is invalid and should be ignored.
+func (i *Interceptor) serve(query []byte, limit int) (resp []byte, ok bool) {
+	var (
+		p   dnsmessage.Parser
+		msg dnsmessage.Message
+	)
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return nil, false
+	}
+	msg.Header = dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		OpCode:             hdr.OpCode,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+	}
+	if msg.Questions, err = p.AllQuestions(); err != nil {
+		return nil, false
+	}
+	if err := p.SkipAllAnswers(); err == nil {
+		if err := p.SkipAllAuthorities(); err == nil {
+			if rs, err := p.AllAdditionals(); err == nil {
+				for _, r := range rs {
+					if r.Header.Type != dnsmessage.TypeOPT {
+						continue
+					}
+
+					// RFC 6891 6.1.1, responder must include OPT if query has
+					var opt dnsmessage.ResourceHeader
+					if err := opt.SetEDNS0(ednsSize, dnsmessage.RCodeSuccess, false); err != nil {
+						return nil, false
+					}
+					msg.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+					if size := min(int(r.Header.Class), ednsSize); size > limit {
+						limit = size
+					}
+					break
+				}
+			}
+		}
+	}
+
+	switch {
+	case hdr.OpCode != 0:
+		msg.RCode = dnsmessage.RCodeNotImplemented
+	case len(msg.Questions) != 1:
+		msg.RCode = dnsmessage.RCodeFormatError
+	default:
+		ctx, cancel := context.WithTimeout(i.ctx, i.cfg.Timeout)
+		msg.Answers, err = i.cfg.Resolver.Resolve(ctx, msg.Questions[0])
+		cancel()
+		if errors.Is(err, ErrNotFound{}) {
+			msg.RCode = dnsmessage.RCodeNameError
+		} else if err != nil {
+			msg.RCode = dnsmessage.RCodeServerFailure
+		}
+		for j := range msg.Answers {
+			if msg.Answers[j].Header.Class == 0 {
+				msg.Answers[j].Header.Class = dnsmessage.ClassINET
+			}
+			if msg.Answers[j].Header.TTL == 0 {
+				msg.Answers[j].Header.TTL = DefaultTTL
+			}
+		}
+	}
+
+	resp, err = msg.Pack()
+	if err != nil {
+		msg.Answers, msg.RCode = nil, dnsmessage.RCodeServerFailure
+		if resp, err = msg.Pack(); err != nil {
+			return nil, false
+		}
+	}
+	if len(resp) > limit {
+		msg.Answers, msg.Truncated = nil, true
+		if resp, err = msg.Pack(); err != nil {
+			return nil, false
+		}
+	}
+	return resp, true
+}
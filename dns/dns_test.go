@@ -0,0 +1,504 @@
+package dns_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/dns"
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type device struct {
+	out chan []byte
+}
+
+var _ wintun.Device = (*device)(nil)
+
+func newDevice() *device { return &device{out: make(chan []byte, 64)} }
+
+func (d *device) Recv(ctx context.Context) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+func (d *device) Release(ip []byte) error        { return nil }
+func (d *device) Alloc(size int) ([]byte, error) { return make([]byte, size), nil }
+func (d *device) Send(ip []byte) error           { d.out <- ip; return nil }
+
+func (d *device) recv(t *testing.T) (packet.Info, []byte) {
+	select {
+	case ip := <-d.out:
+		info, err := packet.Parse(ip)
+		require.NoError(t, err)
+		return info, ip
+	case <-time.After(time.Second * 5):
+		t.Fatal("recv timeout")
+		return packet.Info{}, nil
+	}
+}
+
+var (
+	client4 = netip.MustParseAddrPort("10.6.7.8:5353")
+	server4 = netip.MustParseAddrPort("10.6.7.1:53")
+	client6 = netip.MustParseAddrPort("[fd00::2]:5353")
+	server6 = netip.MustParseAddrPort("[fd00::1]:53")
+)
+
+func query(t *testing.T, name string, typ dnsmessage.Type) []byte {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 0x1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  typ,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	b, err := msg.Pack()
+	require.NoError(t, err)
+	return b
+}
+
+func udpQuery(t *testing.T, client, server netip.AddrPort, q []byte) []byte {
+	h := packet.IP{Src: client.Addr(), Dst: server.Addr()}
+	u := &packet.UDPSegment{SrcPort: client.Port(), DstPort: server.Port(), Payload: q}
+	n, err := packet.Size(h, u)
+	require.NoError(t, err)
+	b := make([]byte, n)
+	_, err = packet.Build(b, h, u)
+	require.NoError(t, err)
+	return b
+}
+
+func tcpSegment(t *testing.T, client, server netip.AddrPort, seq, ack uint32, flags uint8, payload []byte) []byte {
+	h := packet.IP{Src: client.Addr(), Dst: server.Addr()}
+	s := &packet.TCPSegment{
+		SrcPort: client.Port(), DstPort: server.Port(),
+		Seq: seq, Ack: ack, Flags: flags, Window: 0xffff,
+		Payload: payload,
+	}
+	n, err := packet.Size(h, s)
+	require.NoError(t, err)
+	b := make([]byte, n)
+	_, err = packet.Build(b, h, s)
+	require.NoError(t, err)
+	return b
+}
+
+func parse(t *testing.T, b []byte) dnsmessage.Message {
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(b))
+	require.True(t, msg.Response)
+	require.Equal(t, uint16(0x1234), msg.ID)
+	return msg
+}
+
+var static = &dns.Static{Records: map[string][]netip.Addr{
+	"example.com": {
+		netip.MustParseAddr("1.2.3.4"),
+		netip.MustParseAddr("2001:db8::1"),
+	},
+}}
+
+func Test_Intercept_UDP(t *testing.T) {
+	it, err := dns.New(dns.Config{Resolver: static})
+	require.NoError(t, err)
+	defer it.Close()
+
+	t.Run("ipv4", func(t *testing.T) {
+		dev := newDevice()
+
+		handled, err := it.Intercept(dev, udpQuery(t, client4, server4, query(t, "Example.com.", dnsmessage.TypeA)))
+		require.NoError(t, err)
+		require.True(t, handled)
+
+		info, ip := dev.recv(t)
+		require.Equal(t, server4, netip.AddrPortFrom(info.Src, info.SrcPort))
+		require.Equal(t, client4, netip.AddrPortFrom(info.Dst, info.DstPort))
+
+		msg := parse(t, info.Payload(ip))
+		require.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+		require.Len(t, msg.Answers, 1)
+		require.Equal(t, [4]byte{1, 2, 3, 4}, msg.Answers[0].Body.(*dnsmessage.AResource).A)
+		require.Equal(t, uint32(dns.DefaultTTL), msg.Answers[0].Header.TTL)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		dev := newDevice()
+
+		handled, err := it.Intercept(dev, udpQuery(t, client6, server6, query(t, "example.com.", dnsmessage.TypeAAAA)))
+		require.NoError(t, err)
+		require.True(t, handled)
+
+		info, ip := dev.recv(t)
+		require.Equal(t, server6.Addr(), info.Src)
+
+		msg := parse(t, info.Payload(ip))
+		require.Len(t, msg.Answers, 1)
+		require.Equal(t, netip.MustParseAddr("2001:db8::1").As16(), msg.Answers[0].Body.(*dnsmessage.AAAAResource).AAAA)
+	})
+
+	t.Run("nxdomain", func(t *testing.T) {
+		dev := newDevice()
+
+		_, err := it.Intercept(dev, udpQuery(t, client4, server4, query(t, "not.exist.", dnsmessage.TypeA)))
+		require.NoError(t, err)
+
+		info, ip := dev.recv(t)
+		msg := parse(t, info.Payload(ip))
+		require.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+	})
+
+	t.Run("not-dns", func(t *testing.T) {
+		dev := newDevice()
+
+		other := netip.AddrPortFrom(server4.Addr(), 80)
+		handled, err := it.Intercept(dev, udpQuery(t, client4, other, query(t, "example.com.", dnsmessage.TypeA)))
+		require.NoError(t, err)
+		require.False(t, handled)
+	})
+}
+
+func Test_Intercept_Servers(t *testing.T) {
+	it, err := dns.New(dns.Config{
+		Servers:  []netip.Addr{server4.Addr()},
+		Resolver: static,
+	})
+	require.NoError(t, err)
+	defer it.Close()
+	dev := newDevice()
+
+	handled, err := it.Intercept(dev, udpQuery(t, client6, server6, query(t, "example.com.", dnsmessage.TypeA)))
+	require.NoError(t, err)
+	require.False(t, handled)
+
+	handled, err = it.Intercept(dev, udpQuery(t, client4, server4, query(t, "example.com.", dnsmessage.TypeA)))
+	require.NoError(t, err)
+	require.True(t, handled)
+	dev.recv(t)
+}
+
+func Test_Intercept_Truncate(t *testing.T) {
+	var addrs []netip.Addr
+	for i := 0; i < 64; i++ {
+		addrs = append(addrs, netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}))
+	}
+	it, err := dns.New(dns.Config{Resolver: &dns.Static{
+		Records: map[string][]netip.Addr{"many.com.": addrs},
+	}})
+	require.NoError(t, err)
+	defer it.Close()
+	dev := newDevice()
+
+	_, err = it.Intercept(dev, udpQuery(t, client4, server4, query(t, "many.com.", dnsmessage.TypeA)))
+	require.NoError(t, err)
+
+	info, ip := dev.recv(t)
+	require.LessOrEqual(t, len(info.Payload(ip)), 512)
+	msg := parse(t, info.Payload(ip))
+	require.True(t, msg.Truncated)
+	require.Empty(t, msg.Answers)
+}
+
+func Test_Intercept_EDNS(t *testing.T) {
+	var addrs []netip.Addr
+	for i := 0; i < 64; i++ {
+		addrs = append(addrs, netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}))
+	}
+	it, err := dns.New(dns.Config{Resolver: &dns.Static{
+		Records: map[string][]netip.Addr{"many.com.": addrs},
+	}})
+	require.NoError(t, err)
+	defer it.Close()
+	dev := newDevice()
+
+	var q dnsmessage.Message
+	require.NoError(t, q.Unpack(query(t, "many.com.", dnsmessage.TypeA)))
+	var opt dnsmessage.ResourceHeader
+	require.NoError(t, opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false))
+	q.Additionals = []dnsmessage.Resource{{Header: opt, Body: &dnsmessage.OPTResource{}}}
+	b, err := q.Pack()
+	require.NoError(t, err)
+
+	_, err = it.Intercept(dev, udpQuery(t, client4, server4, b))
+	require.NoError(t, err)
+
+	info, ip := dev.recv(t)
+	require.Greater(t, len(info.Payload(ip)), 512)
+	msg := parse(t, info.Payload(ip))
+	require.False(t, msg.Truncated)
+	require.Len(t, msg.Answers, 64)
+	require.Len(t, msg.Additionals, 1)
+	require.Equal(t, dnsmessage.TypeOPT, msg.Additionals[0].Header.Type)
+	require.Equal(t, dnsmessage.Class(4096), msg.Additionals[0].Header.Class)
+
+	// without EDNS, no OPT
+	_, err = it.Intercept(dev, udpQuery(t, client4, server4, query(t, "many.com.", dnsmessage.TypeA)))
+	require.NoError(t, err)
+	info, ip = dev.recv(t)
+	require.Empty(t, parse(t, info.Payload(ip)).Additionals)
+}
+
+func Test_Intercept_TCP(t *testing.T) {
+	it, err := dns.New(dns.Config{Resolver: static})
+	require.NoError(t, err)
+	defer it.Close()
+
+	for _, e := range []struct{ client, server netip.AddrPort }{
+		{client4, server4},
+		{client6, server6},
+	} {
+		dev := newDevice()
+		const iss = 1000
+
+		// handshake
+		_, err = it.Intercept(dev, tcpSegment(t, e.client, e.server, iss, 0, packet.SYN, nil))
+		require.NoError(t, err)
+		info, ip := dev.recv(t)
+		require.Equal(t, packet.SYN|packet.ACK, info.TCPFlags)
+		tcp := info.Transport(ip)
+		require.Equal(t, uint32(iss+1), binary.BigEndian.Uint32(tcp[8:]))
+		sndNxt := binary.BigEndian.Uint32(tcp[4:]) + 1
+
+		// two pipelined queries, in two segments, then FIN
+		q := query(t, "example.com.", dnsmessage.TypeA)
+		var data []byte
+		for i := 0; i < 2; i++ {
+			data = binary.BigEndian.AppendUint16(data, uint16(len(q)))
+			data = append(data, q...)
+		}
+		seq := uint32(iss + 1)
+		for _, p := range [][]byte{data[:5], data[5:]} {
+			_, err = it.Intercept(dev, tcpSegment(t, e.client, e.server, seq, sndNxt, packet.ACK|packet.PSH, p))
+			require.NoError(t, err)
+			seq += uint32(len(p))
+		}
+		_, err = it.Intercept(dev, tcpSegment(t, e.client, e.server, seq, sndNxt, packet.ACK|packet.FIN, nil))
+		require.NoError(t, err)
+
+		var (
+			stream []byte
+			fin    bool
+		)
+		for !fin {
+			info, ip := dev.recv(t)
+			tcp := info.Transport(ip)
+			require.Equal(t, e.server.Port(), info.SrcPort)
+			if p := info.Payload(ip); len(p) > 0 {
+				require.Equal(t, sndNxt+uint32(len(stream)), binary.BigEndian.Uint32(tcp[4:]))
+				stream = append(stream, p...)
+			}
+			if fin = info.TCPFlags&packet.FIN != 0; fin {
+				require.Equal(t, seq+1, binary.BigEndian.Uint32(tcp[8:]))
+			}
+		}
+
+		for i := 0; i < 2; i++ {
+			n := int(binary.BigEndian.Uint16(stream))
+			msg := parse(t, stream[2:2+n])
+			require.Len(t, msg.Answers, 1)
+			stream = stream[2+n:]
+		}
+		require.Empty(t, stream)
+	}
+}
+
+type upstream map[string][]netip.Addr
+
+func (u upstream) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := u[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var ret []netip.Addr
+	for _, a := range addrs {
+		if (network == "ip4") == a.Is4() {
+			ret = append(ret, a)
+		}
+	}
+	return ret, nil
+}
+
+func Test_Resolver(t *testing.T) {
+	var (
+		ctx = context.Background()
+		q   = dnsmessage.Question{Name: dnsmessage.MustNewName("foo.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	)
+
+	t.Run("forward", func(t *testing.T) {
+		f := &dns.Forward{Upstream: upstream{"foo.com": {netip.MustParseAddr("5.6.7.8")}}, TTL: 10}
+
+		rs, err := f.Resolve(ctx, q)
+		require.NoError(t, err)
+		require.Len(t, rs, 1)
+		require.Equal(t, uint32(10), rs[0].Header.TTL)
+
+		q := q
+		q.Name = dnsmessage.MustNewName("bar.com.")
+		_, err = f.Resolve(ctx, q)
+		require.ErrorIs(t, err, dns.ErrNotFound{})
+	})
+
+	t.Run("chain", func(t *testing.T) {
+		c := dns.Chain{
+			static,
+			&dns.Forward{Upstream: upstream{"foo.com": {netip.MustParseAddr("5.6.7.8")}}},
+		}
+
+		rs, err := c.Resolve(ctx, q)
+		require.NoError(t, err)
+		require.Equal(t, [4]byte{5, 6, 7, 8}, rs[0].Body.(*dnsmessage.AResource).A)
+
+		q := q
+		q.Name = dnsmessage.MustNewName("bar.com.")
+		_, err = c.Resolve(ctx, q)
+		require.ErrorIs(t, err, dns.ErrNotFound{})
+	})
+}
+
+func Test_Intercept_Closed(t *testing.T) {
+	it, err := dns.New(dns.Config{Resolver: static})
+	require.NoError(t, err)
+	require.NoError(t, it.Close())
+
+	handled, err := it.Intercept(newDevice(), udpQuery(t, client4, server4, query(t, "example.com.", dnsmessage.TypeA)))
+	require.True(t, handled)
+	require.ErrorIs(t, err, dns.ErrClosed{})
+
+	_, err = dns.New(dns.Config{})
+	require.Error(t, err)
+}
+
+func Test_Intercept_TCP_Retransmit(t *testing.T) {
+	it, err := dns.New(dns.Config{Resolver: static})
+	require.NoError(t, err)
+	defer it.Close()
+	dev := newDevice()
+	const iss = 1000
+
+	// SYN-ACK lost, peer retransmit SYN
+	var sndNxt uint32
+	for i := 0; i < 2; i++ {
+		_, err = it.Intercept(dev, tcpSegment(t, client4, server4, iss, 0, packet.SYN, nil))
+		require.NoError(t, err)
+		info, ip := dev.recv(t)
+		require.Equal(t, packet.SYN|packet.ACK, info.TCPFlags)
+		sndNxt = binary.BigEndian.Uint32(info.Transport(ip)[4:]) + 1
+	}
+
+	q := query(t, "example.com.", dnsmessage.TypeA)
+	data := append(binary.BigEndian.AppendUint16(nil, uint16(len(q))), q...)
+	seq := uint32(iss + 1 + len(data))
+	_, err = it.Intercept(dev, tcpSegment(t, client4, server4, iss+1, sndNxt, packet.ACK|packet.PSH, data))
+	require.NoError(t, err)
+
+	// response not acknowledged, retransmitted from sndNxt
+	var resp []byte
+	for n := 0; n < 2; {
+		info, ip := dev.recv(t)
+		if p := info.Payload(ip); len(p) > 0 {
+			require.Equal(t, sndNxt, binary.BigEndian.Uint32(info.Transport(ip)[4:]))
+			require.Equal(t, seq, binary.BigEndian.Uint32(info.Transport(ip)[8:]))
+			resp = p
+			n++
+		}
+	}
+	msg := parse(t, resp[2:])
+	require.Len(t, msg.Answers, 1)
+
+	// acknowledged, then FIN
+	sndNxt += uint32(len(resp))
+	_, err = it.Intercept(dev, tcpSegment(t, client4, server4, seq, sndNxt, packet.ACK|packet.FIN, nil))
+	require.NoError(t, err)
+	for {
+		info, ip := dev.recv(t)
+		if info.TCPFlags&packet.FIN != 0 {
+			require.Equal(t, sndNxt, binary.BigEndian.Uint32(info.Transport(ip)[4:]))
+			require.Equal(t, seq+1, binary.BigEndian.Uint32(info.Transport(ip)[8:]))
+			break
+		}
+		require.Empty(t, info.Payload(ip))
+	}
+	_, err = it.Intercept(dev, tcpSegment(t, client4, server4, seq+1, sndNxt+1, packet.ACK, nil))
+	require.NoError(t, err)
+
+	// closed, not retransmit
+	select {
+	case <-dev.out:
+		t.Fatal("retransmit after closed")
+	case <-time.After(time.Millisecond * 500):
+	}
+}
+
+func Test_Intercept_TCP_Close(t *testing.T) {
+	it, err := dns.New(dns.Config{Resolver: static})
+	require.NoError(t, err)
+	dev := newDevice()
+	const iss = 1000
+
+	_, err = it.Intercept(dev, tcpSegment(t, client4, server4, iss, 0, packet.SYN, nil))
+	require.NoError(t, err)
+	info, ip := dev.recv(t)
+	sndNxt := binary.BigEndian.Uint32(info.Transport(ip)[4:]) + 1
+
+	q := query(t, "example.com.", dnsmessage.TypeA)
+	data := append(binary.BigEndian.AppendUint16(nil, uint16(len(q))), q...)
+	_, err = it.Intercept(dev, tcpSegment(t, client4, server4, iss+1, sndNxt, packet.ACK|packet.PSH, data))
+	require.NoError(t, err)
+	for {
+		if info, ip := dev.recv(t); len(info.Payload(ip)) > 0 {
+			break
+		}
+	}
+
+	// response not acknowledged, but not retransmit after closed
+	require.NoError(t, it.Close())
+	for len(dev.out) > 0 {
+		<-dev.out
+	}
+	select {
+	case <-dev.out:
+		t.Fatal("retransmit after interceptor closed")
+	case <-time.After(time.Millisecond * 500):
+	}
+
+	_, err = it.Intercept(dev, tcpSegment(t, client4, server4, iss, 0, packet.SYN, nil))
+	require.ErrorIs(t, err, dns.ErrClosed{})
+}
+
+type blocked chan struct{}
+
+func (b blocked) Resolve(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error) {
+	select {
+	case <-b:
+	case <-ctx.Done():
+	}
+	return nil, ctx.Err()
+}
+
+func Test_Intercept_MaxQueries(t *testing.T) {
+	var block = make(blocked)
+	it, err := dns.New(dns.Config{Resolver: block, MaxQueries: 1})
+	require.NoError(t, err)
+	defer it.Close()
+	dev := newDevice()
+
+	q := udpQuery(t, client4, server4, query(t, "example.com.", dnsmessage.TypeA))
+	_, err = it.Intercept(dev, q)
+	require.NoError(t, err)
+
+	handled, err := it.Intercept(dev, q)
+	require.True(t, handled)
+	require.ErrorIs(t, err, dns.ErrBusy{})
+	require.Equal(t, uint64(1), it.Dropped())
+
+	close(block)
+	dev.recv(t)
+	_, err = it.Intercept(dev, q)
+	require.NoError(t, err)
+}
@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// default ttl of answers, in seconds
+const DefaultTTL = 60
+
+// Resolver answer dns question, return ErrNotFound if the name not exist,
+// the answer resource's header class and ttl can be zero, they will be filled
+type Resolver interface {
+	Resolve(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error)
+}
+
+type ErrNotFound struct{}
+
+func (ErrNotFound) Error() string { return "dns name not found" }
+
+// Static resolver with static A/AAAA records, the names are case-insensitive,
+// with or without trailing dot
+type Static struct {
+	Records map[string][]netip.Addr
+	TTL     uint32
+}
+
+var _ Resolver = (*Static)(nil)
+
+func (s *Static) Resolve(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error) {
+	addrs, ok := s.Records[q.Name.String()]
+	if !ok {
+		for name, as := range s.Records {
+			if canonical(name) == canonical(q.Name.String()) {
+				addrs, ok = as, true
+				break
+			}
+		}
+	}
+	if !ok {
+		return nil, errors.WithStack(ErrNotFound{})
+	}
+	return answers(q, addrs, s.TTL), nil
+}
+
+// Upstream resolver used by Forward, *net.Resolver implement it
+type Upstream interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+var _ Upstream = (*net.Resolver)(nil)
+
+// Forward resolver forward A/AAAA questions to upstream, the other type
+// questions answered empty
+type Forward struct {
+	Upstream Upstream
+	TTL      uint32
+}
+
+var _ Resolver = (*Forward)(nil)
+
+func (f *Forward) Resolve(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error) {
+	var network string
+	switch q.Type {
+	case dnsmessage.TypeA:
+		network = "ip4"
+	case dnsmessage.TypeAAAA:
+		network = "ip6"
+	default:
+		return nil, nil
+	}
+
+	addrs, err := f.Upstream.LookupNetIP(ctx, network, strings.TrimSuffix(q.Name.String(), "."))
+	if err != nil {
+		var e *net.DNSError
+		if errors.As(err, &e) && e.IsNotFound {
+			return nil, errors.WithStack(ErrNotFound{})
+		}
+		return nil, errors.WithStack(err)
+	}
+	return answers(q, addrs, f.TTL), nil
+}
+
+// Chain resolver try resolvers in order, until one not return ErrNotFound
+type Chain []Resolver
+
+var _ Resolver = (Chain)(nil)
+
+func (c Chain) Resolve(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error) {
+	for _, r := range c {
+		rs, err := r.Resolve(ctx, q)
+		if !errors.Is(err, ErrNotFound{}) {
+			return rs, err
+		}
+	}
+	return nil, errors.WithStack(ErrNotFound{})
+}
+
+func canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// answers build A/AAAA answers of question from addrs, the addrs not match
+// question type are ignored
+func answers(q dnsmessage.Question, addrs []netip.Addr, ttl uint32) []dnsmessage.Resource {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	var rs []dnsmessage.Resource
+	for _, addr := range addrs {
+		hdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl}
+		switch addr = addr.Unmap(); {
+		case addr.Is4() && q.Type == dnsmessage.TypeA:
+			hdr.Type = dnsmessage.TypeA
+			rs = append(rs, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AResource{A: addr.As4()}})
+		case addr.Is6() && q.Type == dnsmessage.TypeAAAA:
+			hdr.Type = dnsmessage.TypeAAAA
+			rs = append(rs, dnsmessage.Resource{Header: hdr, Body: &dnsmessage.AAAAResource{AAAA: addr.As16()}})
+		}
+	}
+	return rs
+}
@@ -0,0 +1,355 @@
+package dns
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/pkg/errors"
+)
+
+// default mss if SYN not carry MSS option
+const defaultMSS = 536
+
+// retransmission timeout bound, the timeout doubled after every retransmission
+const (
+	minRTO = time.Millisecond * 200
+	maxRTO = time.Second * 2
+)
+
+type connKey struct {
+	local, remote netip.AddrPort
+}
+
+// tcpConn minimal server side tcp connection for dns over tcp, it only
+// acknowledge in-order segments, the unacknowledged data and FIN are
+// retransmitted (go-back-n) by timer, SYN-ACK is retransmitted when peer
+// retransmit SYN. the connection is closed after peer FIN received, all
+// queries answered and our FIN acknowledged, or idle Timeout.
+type tcpConn struct {
+	mu sync.Mutex
+
+	dev wintun.Device
+	key connKey
+	mss int
+
+	iss, rcvNxt uint32
+	// una first unacknowledged sequence, out the data from una, include
+	// sent but unacknowledged and not sent
+	una, sndNxt uint32
+	out         []byte
+	wnd         uint16
+
+	buf                []byte
+	pending            int
+	finRecv            bool
+	finQueued, finSent bool
+
+	rto      time.Duration
+	timer    *time.Timer
+	deadline time.Time // of timer, the stopped timer may still fire
+	timeout  func()    // retransmission timer callback
+
+	last   atomic.Int64 // unix nano of last received segment
+	closed atomic.Bool
+}
+
+func (i *Interceptor) tcp(dev wintun.Device, info *packet.Info, ip []byte) error {
+	var (
+		hdr   = info.Transport(ip)
+		flags = info.TCPFlags
+		seq   = binary.BigEndian.Uint32(hdr[4:])
+		ack   = binary.BigEndian.Uint32(hdr[8:])
+		wnd   = binary.BigEndian.Uint16(hdr[14:])
+		key   = connKey{
+			local:  netip.AddrPortFrom(info.Dst, info.DstPort),
+			remote: netip.AddrPortFrom(info.Src, info.SrcPort),
+		}
+	)
+
+	i.mu.Lock()
+	if i.ctx.Err() != nil {
+		i.mu.Unlock()
+		return errors.WithStack(ErrClosed{})
+	}
+	i.sweepLocked()
+	c := i.conns[key]
+	switch {
+	case flags&packet.RST != 0:
+		if c != nil {
+			c.closed.Store(true)
+		}
+		delete(i.conns, key)
+		c = nil
+	case flags&packet.SYN != 0 && flags&packet.ACK == 0:
+		if c == nil || c.rcvNxt != seq+1 || c.sndNxt != c.iss+1 {
+			if c != nil {
+				c.closed.Store(true)
+			}
+			c = &tcpConn{
+				dev: dev, key: key, wnd: wnd, rto: minRTO,
+				mss: parseMSS(hdr[:info.PayloadOffset-info.TransportOffset]),
+			}
+			c.timeout = func() { i.retransmit(c) }
+			c.iss = rand.Uint32()
+			c.una, c.sndNxt, c.rcvNxt = c.iss+1, c.iss+1, seq+1
+			i.conns[key] = c
+		}
+		c.last.Store(time.Now().UnixNano())
+		i.mu.Unlock()
+
+		// SYN-ACK is not tracked by una, it's retransmitted by peer SYN
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.send(c.iss, packet.SYN|packet.ACK, nil)
+	}
+	i.mu.Unlock()
+	if c == nil || flags&packet.SYN != 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last.Store(time.Now().UnixNano())
+
+	if flags&packet.ACK != 0 {
+		if c.acknowledge(ack, wnd) {
+			i.remove(c)
+			return nil
+		}
+	}
+
+	payload := info.Payload(ip)
+	if len(payload) == 0 && flags&packet.FIN == 0 {
+		return c.push(false)
+	} else if seq != c.rcvNxt || c.finRecv {
+		return c.send(c.sndNxt, packet.ACK, nil) // duplicate ack
+	}
+	c.buf = append(c.buf, payload...)
+	c.rcvNxt += uint32(len(payload))
+	if flags&packet.FIN != 0 {
+		c.rcvNxt++
+		c.finRecv = true
+	}
+
+	var err error
+	for len(c.buf) >= 2 && err == nil {
+		n := 2 + int(binary.BigEndian.Uint16(c.buf))
+		if len(c.buf) < n {
+			break
+		}
+		query := slices.Clone(c.buf[2:n])
+		c.buf = c.buf[n:]
+
+		c.pending++
+		err = i.goServe(info, query, func(resp []byte) error {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			c.pending--
+			c.write(resp)
+			c.finish()
+			return c.push(false)
+		})
+		if err != nil {
+			// can't answer the query, reset instead of hang the peer
+			c.pending--
+			c.send(c.sndNxt, packet.RST|packet.ACK, nil)
+			i.remove(c)
+			return err
+		}
+	}
+	if e := c.send(c.sndNxt, packet.ACK, nil); err == nil {
+		err = e
+	}
+	c.finish()
+	if e := c.push(false); err == nil {
+		err = e
+	}
+	return err
+}
+
+// acknowledge process peer ACK, return true if our FIN acknowledged, then
+// the connection is finished
+func (c *tcpConn) acknowledge(ack uint32, wnd uint16) bool {
+	c.wnd = wnd
+	if !after(ack, c.una) || after(ack, c.sndNxt) {
+		return false
+	}
+
+	n := int(ack - c.una)
+	c.out = c.out[min(n, len(c.out)):]
+	c.una = ack
+	c.rto = minRTO
+	if c.una == c.sndNxt {
+		c.stopTimer()
+		return c.finSent
+	}
+	c.resetTimer()
+	return false
+}
+
+// finish queue FIN if peer FIN received and all queries answered
+func (c *tcpConn) finish() {
+	if c.finRecv && c.pending == 0 {
+		c.finQueued = true
+	}
+}
+
+// remove connection from interceptor, must hold c.mu
+func (i *Interceptor) remove(c *tcpConn) {
+	c.closed.Store(true)
+	c.stopTimer()
+
+	i.mu.Lock()
+	if i.conns[c.key] == c {
+		delete(i.conns, c.key)
+	}
+	i.mu.Unlock()
+}
+
+// close stop the connection and its retransmission timer
+func (c *tcpConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed.Store(true)
+	c.stopTimer()
+}
+
+// sweepLocked remove idle connections, at most once per Timeout
+func (i *Interceptor) sweepLocked() {
+	now := time.Now()
+	if now.Sub(i.sweep) < i.cfg.Timeout {
+		return
+	}
+	i.sweep = now
+
+	for key, c := range i.conns {
+		if now.Sub(time.Unix(0, c.last.Load())) > i.cfg.Timeout {
+			c.closed.Store(true)
+			delete(i.conns, key)
+		}
+	}
+}
+
+// write queue length-prefixed dns message
+func (c *tcpConn) write(msg []byte) {
+	c.out = binary.BigEndian.AppendUint16(c.out, uint16(len(msg)))
+	c.out = append(c.out, msg...)
+}
+
+// push send the queued data within peer window, segmented by mss, then FIN
+// if queued. probe send at least one byte even if peer window is zero.
+func (c *tcpConn) push(probe bool) error {
+	if c.closed.Load() {
+		return nil
+	}
+
+	var (
+		sent  = int(c.sndNxt - c.una)
+		limit = min(len(c.out), int(c.wnd))
+	)
+	if probe && limit == 0 && len(c.out) > 0 {
+		limit = 1
+	}
+	for sent < limit {
+		n := min(limit-sent, c.mss)
+		if err := c.send(c.sndNxt, packet.PSH|packet.ACK, c.out[sent:sent+n]); err != nil {
+			return err
+		}
+		c.sndNxt += uint32(n)
+		sent += n
+	}
+	if c.finQueued && !c.finSent && sent == len(c.out) {
+		if err := c.send(c.sndNxt, packet.FIN|packet.ACK, nil); err != nil {
+			return err
+		}
+		c.sndNxt++
+		c.finSent = true
+	}
+
+	if (c.una != c.sndNxt || len(c.out) > 0) && c.timer == nil {
+		c.resetTimer()
+	}
+	return nil
+}
+
+// retransmit resend data from una, called by timer
+func (i *Interceptor) retransmit(c *tcpConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Load() || c.timer == nil || time.Now().Before(c.deadline) {
+		return // stopped or reset
+	}
+	c.timer = nil
+	if i.ctx.Err() != nil ||
+		time.Since(time.Unix(0, c.last.Load())) > i.cfg.Timeout {
+		i.remove(c)
+		return
+	}
+
+	c.sndNxt, c.finSent = c.una, false
+	c.rto = min(c.rto*2, maxRTO)
+	c.push(true)
+}
+
+func (c *tcpConn) resetTimer() {
+	c.stopTimer()
+	c.deadline = time.Now().Add(c.rto)
+	c.timer = time.AfterFunc(c.rto, c.timeout)
+}
+
+func (c *tcpConn) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+func (c *tcpConn) send(seq uint32, flags uint8, payload []byte) error {
+	return wintun.Inject(c.dev,
+		packet.IP{Src: c.key.local.Addr(), Dst: c.key.remote.Addr()},
+		&packet.TCPSegment{
+			SrcPort: c.key.local.Port(),
+			DstPort: c.key.remote.Port(),
+			Seq:     seq,
+			Ack:     c.rcvNxt,
+			Flags:   flags,
+			Window:  0xffff,
+			Payload: payload,
+		},
+	)
+}
+
+// after whether sequence a is after b
+func after(a, b uint32) bool { return int32(a-b) > 0 }
+
+// parseMSS get MSS option of tcp header
+func parseMSS(hdr []byte) int {
+	opts := hdr[packet.TCPMinSize:]
+	for len(opts) > 0 {
+		switch opts[0] {
+		case 0: // end of option list
+			return defaultMSS
+		case 1: // nop
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || int(opts[1]) < 2 || int(opts[1]) > len(opts) {
+			return defaultMSS
+		}
+		if opts[0] == 2 && opts[1] == 4 {
+			if mss := int(binary.BigEndian.Uint16(opts[2:])); mss > 0 {
+				return mss
+			}
+		}
+		opts = opts[opts[1]:]
+	}
+	return defaultMSS
+}
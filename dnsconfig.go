@@ -0,0 +1,131 @@
+package wintun
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maximum domain name length, without trailing dot
+const maxDomainLen = 253
+
+// NRPTRule name resolution policy table rule, the queries of names under
+// Namespaces are resolved by Servers. the namespace matches the domain and
+// its subdomains, such as "corp.example.com"
+type NRPTRule struct {
+	Namespaces []string
+	Servers    []netip.Addr
+}
+
+func (r NRPTRule) String() string {
+	return strings.Join(r.names(), ",") + " -> " + r.servers()
+}
+
+// validate validate and normalize rule
+func (r NRPTRule) validate() (NRPTRule, error) {
+	if len(r.Namespaces) == 0 {
+		return NRPTRule{}, errors.New("require nrpt namespace")
+	} else if len(r.Servers) == 0 {
+		return NRPTRule{}, errors.New("require nrpt server")
+	}
+
+	var err error
+	r.Servers, r.Namespaces, err = validateDNS(r.Servers, r.Namespaces)
+	return r, err
+}
+
+// names registry Name values, leading dot means suffix match
+func (r NRPTRule) names() []string {
+	var names = make([]string, 0, len(r.Namespaces))
+	for _, ns := range r.Namespaces {
+		names = append(names, "."+ns)
+	}
+	return names
+}
+
+// servers registry GenericDNSServers value
+func (r NRPTRule) servers() string {
+	var ss = make([]string, 0, len(r.Servers))
+	for _, s := range r.Servers {
+		ss = append(ss, s.String())
+	}
+	return strings.Join(ss, ";")
+}
+
+// parseNRPTRule parse rule from registry Name and GenericDNSServers values
+func parseNRPTRule(names []string, servers string) (NRPTRule, error) {
+	var r NRPTRule
+	r.Namespaces = names
+	for _, s := range strings.Split(servers, ";") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return NRPTRule{}, errors.Errorf("invalid nrpt server %q", s)
+		}
+		r.Servers = append(r.Servers, addr)
+	}
+	return r.validate()
+}
+
+// nrptKeyPrefix registry key name prefix of nrpt rules set for adapter
+func nrptKeyPrefix(guid GUID) string {
+	return "wintun-go-" + guid.String() + "-"
+}
+
+func nrptKeyName(guid GUID, i int) string {
+	return nrptKeyPrefix(guid) + strconv.Itoa(i)
+}
+
+// validateDNS validate and normalize dns servers and search domains, the
+// duplicates are removed
+func validateDNS(servers []netip.Addr, domains []string) ([]netip.Addr, []string, error) {
+	var ss = make([]netip.Addr, 0, len(servers))
+	for _, s := range servers {
+		s = s.Unmap()
+		if !s.IsValid() || s.IsUnspecified() || s.IsMulticast() {
+			return nil, nil, errors.Errorf("invalid dns server %s", s)
+		}
+		if !slices.Contains(ss, s) {
+			ss = append(ss, s)
+		}
+	}
+
+	var ds = make([]string, 0, len(domains))
+	for _, d := range domains {
+		n, err := normalizeDomain(d)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !slices.Contains(ds, n) {
+			ds = append(ds, n)
+		}
+	}
+	return ss, ds, nil
+}
+
+// normalizeDomain lowercase domain, without leading and trailing dot
+func normalizeDomain(domain string) (string, error) {
+	d := strings.ToLower(strings.TrimSpace(domain))
+	d = strings.TrimSuffix(strings.TrimPrefix(d, "."), ".")
+	if d == "" || len(d) > maxDomainLen {
+		return "", errors.Errorf("invalid dns domain %q", domain)
+	}
+
+	for _, label := range strings.Split(d, ".") {
+		if len(label) == 0 || len(label) > 63 ||
+			label[0] == '-' || label[len(label)-1] == '-' {
+			return "", errors.Errorf("invalid dns domain %q", domain)
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return "", errors.Errorf("invalid dns domain %q", domain)
+			}
+		}
+	}
+	return d, nil
+}
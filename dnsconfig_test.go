@@ -0,0 +1,68 @@
+package wintun
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateDNS(t *testing.T) {
+	servers, domains, err := validateDNS(
+		[]netip.Addr{
+			netip.MustParseAddr("10.0.0.53"),
+			netip.MustParseAddr("::ffff:10.0.0.53"),
+			netip.MustParseAddr("fd00::53"),
+		},
+		[]string{"Corp.Example.com.", ".lab.local", "corp.example.com", "_srv.a-b.io"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("fd00::53")}, servers)
+	require.Equal(t, []string{"corp.example.com", "lab.local", "_srv.a-b.io"}, domains)
+
+	servers, domains, err = validateDNS(nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, servers)
+	require.Empty(t, domains)
+
+	for _, s := range []netip.Addr{{}, netip.IPv4Unspecified(), netip.MustParseAddr("ff02::fb")} {
+		_, _, err := validateDNS([]netip.Addr{s}, nil)
+		require.Error(t, err, s.String())
+	}
+	for _, d := range []string{
+		"", ".", "a..b", "-a.com", "a-.com", "a b.com", "a/b", "例子.com",
+		strings.Repeat("a", 64) + ".com",
+		strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com",
+	} {
+		_, _, err := validateDNS(nil, []string{d})
+		require.Error(t, err, d)
+	}
+}
+
+func Test_NRPTRule(t *testing.T) {
+	r, err := NRPTRule{
+		Namespaces: []string{"Corp.Example.com", "lab.local."},
+		Servers:    []netip.Addr{netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("fd00::53")},
+	}.validate()
+	require.NoError(t, err)
+	require.Equal(t, []string{".corp.example.com", ".lab.local"}, r.names())
+	require.Equal(t, "10.0.0.53;fd00::53", r.servers())
+	require.Equal(t, ".corp.example.com,.lab.local -> 10.0.0.53;fd00::53", r.String())
+
+	p, err := parseNRPTRule(r.names(), r.servers())
+	require.NoError(t, err)
+	require.Equal(t, r, p)
+
+	_, err = NRPTRule{Servers: r.Servers}.validate()
+	require.Error(t, err)
+	_, err = NRPTRule{Namespaces: r.Namespaces}.validate()
+	require.Error(t, err)
+	_, err = parseNRPTRule(r.names(), "10.0.0.53;invalid")
+	require.Error(t, err)
+
+	guid, err := ParseGuid("{6BA7B810-9DAD-11D1-80B4-00C04FD430C8}")
+	require.NoError(t, err)
+	require.Equal(t, "wintun-go-{6BA7B810-9DAD-11D1-80B4-00C04FD430C8}-1", nrptKeyName(guid, 1))
+	require.True(t, strings.HasPrefix(nrptKeyName(guid, 1), nrptKeyPrefix(guid)))
+}
@@ -0,0 +1,231 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// registry key of local NRPT rules
+const nrptKey = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters\DnsPolicyConfig`
+
+var procDnsFlushResolverCache = windows.NewLazySystemDLL("dnsapi.dll").NewProc("DnsFlushResolverCache")
+
+// SetDNS set dns servers and search domains of adapter, replace the previous,
+// empty means clear. it requires Windows 10 2004 or later.
+func (a *Adapter) SetDNS(servers []netip.Addr, domains []string) error {
+	servers, domains, err := validateDNS(servers, domains)
+	if err != nil {
+		return err
+	}
+	luid, err := a.GetAdapterLuid()
+	if err != nil {
+		return err
+	}
+
+	for _, family := range []winipcfg.AddressFamily{windows.AF_INET, windows.AF_INET6} {
+		if err := luid.SetDNS(family, servers, domains); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// SetNRPT replace NRPT rules set for adapter, empty means clear, the rules
+// are removed when adapter Close, or by CleanAdapters if process exit.
+func (a *Adapter) SetNRPT(rules []NRPTRule) error {
+	rules = slices.Clone(rules)
+	for i := range rules {
+		r, err := rules[i].validate()
+		if err != nil {
+			return err
+		}
+		rules[i] = r
+	}
+	guid, err := a.guid()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.handle == 0 {
+		return errors.WithStack(ErrAdapterClosed{})
+	}
+	if err := setNRPT(guid, rules); err != nil {
+		// the rules may be partially set or not removed
+		a.nrpt = true
+		return err
+	}
+	a.nrpt = len(rules) > 0
+	return nil
+}
+
+// NRPT get NRPT rules set for adapter, in the order they are set
+func (a *Adapter) NRPT() ([]NRPTRule, error) {
+	guid, err := a.guid()
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, nrptKey, registry.ENUMERATE_SUB_KEYS)
+	if err == registry.ErrNotExist {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer key.Close()
+
+	names, err := key.ReadSubKeyNames(-1)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	type indexed struct {
+		i int
+		r NRPTRule
+	}
+	var rules []indexed
+	for _, name := range names {
+		suffix, ok := strings.CutPrefix(name, nrptKeyPrefix(guid))
+		if !ok {
+			continue
+		}
+		i, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		r, err := readNRPTRule(key, name)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, indexed{i, r})
+	}
+	slices.SortFunc(rules, func(a, b indexed) int { return a.i - b.i })
+
+	var rs = make([]NRPTRule, 0, len(rules))
+	for _, r := range rules {
+		rs = append(rs, r.r)
+	}
+	return rs, nil
+}
+
+// clearNRPT remove NRPT rules of adapter, require a.mu held
+func (a *Adapter) clearNRPT() error {
+	luid, err := a.luidLocked()
+	if err != nil {
+		return err
+	}
+	guid, err := luid.GUID()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := setNRPT(GUID(*guid), nil); err != nil {
+		return err
+	}
+	a.nrpt = false
+	return nil
+}
+
+func (a *Adapter) guid() (GUID, error) {
+	luid, err := a.GetAdapterLuid()
+	if err != nil {
+		return GUID{}, err
+	}
+	guid, err := luid.GUID()
+	if err != nil {
+		return GUID{}, errors.WithStack(err)
+	}
+	return GUID(*guid), nil
+}
+
+func readNRPTRule(parent registry.Key, name string) (NRPTRule, error) {
+	key, err := registry.OpenKey(parent, name, registry.QUERY_VALUE)
+	if err != nil {
+		return NRPTRule{}, errors.WithStack(err)
+	}
+	defer key.Close()
+
+	names, _, err := key.GetStringsValue("Name")
+	if err != nil {
+		return NRPTRule{}, errors.WithStack(err)
+	}
+	servers, _, err := key.GetStringValue("GenericDNSServers")
+	if err != nil {
+		return NRPTRule{}, errors.WithStack(err)
+	}
+	return parseNRPTRule(names, servers)
+}
+
+// setNRPT replace the NRPT rules of adapter guid
+func setNRPT(guid GUID, rules []NRPTRule) error {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, nrptKey,
+		registry.ENUMERATE_SUB_KEYS|registry.CREATE_SUB_KEY)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer key.Close()
+
+	names, err := key.ReadSubKeyNames(-1)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(name, nrptKeyPrefix(guid)) {
+			if err := registry.DeleteKey(key, name); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	for i, r := range rules {
+		if err := writeNRPTRule(key, nrptKeyName(guid, i), r); err != nil {
+			return err
+		}
+	}
+	return flushResolverCache()
+}
+
+// flushResolverCache flush dns client cache, so the NRPT rules take effect
+func flushResolverCache() error {
+	if err := procDnsFlushResolverCache.Find(); err != nil {
+		return errors.WithStack(err)
+	}
+	r1, _, err := procDnsFlushResolverCache.Call()
+	if r1 == 0 {
+		if err := errnoErr(err); err != nil {
+			return err
+		}
+		return errors.New("flush dns resolver cache failed")
+	}
+	return nil
+}
+
+func writeNRPTRule(parent registry.Key, name string, r NRPTRule) error {
+	key, _, err := registry.CreateKey(parent, name, registry.SET_VALUE)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer key.Close()
+
+	for _, fn := range []func() error{
+		func() error { return key.SetDWordValue("Version", 2) },
+		func() error { return key.SetStringsValue("Name", r.names()) },
+		func() error { return key.SetStringValue("GenericDNSServers", r.servers()) },
+		func() error { return key.SetDWordValue("ConfigOptions", 8) }, // generic dns servers
+		func() error { return key.SetStringValue("IPSECCARestriction", "") },
+	} {
+		if err := fn(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
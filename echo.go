@@ -0,0 +1,118 @@
+package wintun
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/lysShub/wintun-go/packet"
+	"golang.org/x/time/rate"
+)
+
+type EchoConfig struct {
+	// Prefixes the echo requests to address in prefixes will be replied, the
+	// single address use /32 or /128 prefix
+	Prefixes []netip.Prefix
+
+	// Rate maximum replies per second, default 100
+	Rate int
+	// Burst maximum burst replies, default Rate
+	Burst int
+}
+
+func (c *EchoConfig) init() {
+	if c.Rate <= 0 {
+		c.Rate = 100
+	}
+	if c.Burst <= 0 {
+		c.Burst = c.Rate
+	}
+}
+
+// EchoResponder answer ICMP/ICMPv6 echo requests to virtual addresses behind
+// adapter, it can be used as Dispatch handler by Handler.
+type EchoResponder struct {
+	prefixes []netip.Prefix
+	limiter  *rate.Limiter
+	failed   atomic.Uint64
+}
+
+func NewEchoResponder(cfg EchoConfig) *EchoResponder {
+	cfg.init()
+
+	var prefixes = make([]netip.Prefix, 0, len(cfg.Prefixes))
+	for _, p := range cfg.Prefixes {
+		prefixes = append(prefixes, p.Masked())
+	}
+	return &EchoResponder{
+		prefixes: prefixes,
+		limiter:  rate.NewLimiter(rate.Limit(cfg.Rate), cfg.Burst),
+	}
+}
+
+func (r *EchoResponder) contains(addr netip.Addr) bool {
+	for _, p := range r.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reply send echo reply by dev if ip is echo request to the configured
+// prefixes, handled means ip is owned by responder (replied, rate limited or
+// checksum invalid), the ip still need be released by caller.
+func (r *EchoResponder) Reply(dev Device, ip []byte) (handled bool, err error) {
+	info, err := packet.Parse(ip)
+	if err != nil || info.Fragmented {
+		return false, nil
+	}
+	switch {
+	case info.Proto == packet.ICMP && info.ICMPType == packet.ICMPEchoRequest:
+	case info.Proto == packet.ICMPv6 && info.ICMPType == packet.ICMPv6EchoRequest:
+	default:
+		return false, nil
+	}
+	if info.ICMPCode != 0 || !r.contains(info.Dst) {
+		return false, nil
+	}
+
+	icmp := info.Transport(ip)
+	var sum uint32
+	if info.Proto == packet.ICMPv6 {
+		sum = packet.PseudoSum(info.Src, info.Dst, info.Proto, len(icmp))
+	}
+	if packet.Checksum(icmp, sum) != 0xffff {
+		return true, nil
+	} else if !r.limiter.Allow() {
+		return true, nil
+	}
+
+	return true, Inject(dev,
+		packet.IP{Src: info.Dst, Dst: info.Src},
+		&packet.Echo{
+			Reply: true,
+			ID:    binary.BigEndian.Uint16(icmp[4:]),
+			Seq:   binary.BigEndian.Uint16(icmp[6:]),
+			Data:  info.Payload(ip),
+		},
+	)
+}
+
+// Failed replies count that failed to send by Handler, such as ring full
+func (r *EchoResponder) Failed() uint64 { return r.failed.Load() }
+
+// Handler wrap next handler, the echo requests to configured prefixes are
+// answered by responder, others are passed to next, next can be nil. the
+// replies failed to send are counted by Failed.
+func (r *EchoResponder) Handler(next Handler) Handler {
+	return func(dev Device, ip []byte) {
+		handled, err := r.Reply(dev, ip)
+		if err != nil {
+			r.failed.Add(1)
+		}
+		if !handled && next != nil {
+			next(dev, ip)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package wintun
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func buildEcho(t *testing.T, src, dst string, data string) []byte {
+	h := packet.IP{Src: netip.MustParseAddr(src), Dst: netip.MustParseAddr(dst)}
+	e := &packet.Echo{ID: 7, Seq: 9, Data: []byte(data)}
+
+	n, err := packet.Size(h, e)
+	require.NoError(t, err)
+	b := make([]byte, n)
+	_, err = packet.Build(b, h, e)
+	require.NoError(t, err)
+	return b
+}
+
+func Test_EchoResponder(t *testing.T) {
+	r := NewEchoResponder(EchoConfig{
+		Prefixes: []netip.Prefix{
+			netip.MustParsePrefix("10.6.7.0/24"),
+			netip.MustParsePrefix("fd00::1/128"),
+		},
+	})
+
+	for _, e := range []struct{ src, dst string }{
+		{"10.6.7.1", "10.6.7.8"},
+		{"fd00::2", "fd00::1"},
+	} {
+		dev := newMemDevice(1)
+
+		handled, err := r.Reply(dev, buildEcho(t, e.src, e.dst, "ping"))
+		require.NoError(t, err)
+		require.True(t, handled)
+
+		reply := <-dev.out
+		info, err := packet.Parse(reply)
+		require.NoError(t, err)
+		require.Equal(t, e.dst, info.Src.String())
+		require.Equal(t, e.src, info.Dst.String())
+		if info.Version == 4 {
+			require.Equal(t, packet.ICMPEchoReply, info.ICMPType)
+		} else {
+			require.Equal(t, packet.ICMPv6EchoReply, info.ICMPType)
+		}
+		icmp := info.Transport(reply)
+		require.Equal(t, []byte{0, 7, 0, 9}, icmp[4:8])
+		require.Equal(t, "ping", string(info.Payload(reply)))
+	}
+
+	t.Run("not-handled", func(t *testing.T) {
+		dev := newMemDevice(1)
+		for _, ip := range [][]byte{
+			buildEcho(t, "10.6.7.1", "10.6.8.1", "ping"),
+			buildEcho(t, "fd00::2", "fd00::3", "ping"),
+			buildUDP(
+				netip.MustParseAddrPort("10.6.7.1:1"),
+				netip.MustParseAddrPort("10.6.7.8:2"),
+				nil,
+			),
+			{0x45, 0},
+		} {
+			handled, err := r.Reply(dev, ip)
+			require.NoError(t, err)
+			require.False(t, handled)
+		}
+		require.Zero(t, len(dev.out))
+	})
+
+	t.Run("invalid-checksum", func(t *testing.T) {
+		dev := newMemDevice(1)
+		ip := buildEcho(t, "10.6.7.1", "10.6.7.8", "ping")
+		ip[len(ip)-1]++
+
+		handled, err := r.Reply(dev, ip)
+		require.NoError(t, err)
+		require.True(t, handled)
+		require.Zero(t, len(dev.out))
+	})
+}
+
+func Test_EchoResponder_RateLimit(t *testing.T) {
+	r := NewEchoResponder(EchoConfig{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("10.6.7.8/32")},
+		Rate:     1,
+		Burst:    2,
+	})
+	dev := newMemDevice(8)
+	ip := buildEcho(t, "10.6.7.1", "10.6.7.8", "ping")
+
+	for i := 0; i < 4; i++ {
+		handled, err := r.Reply(dev, ip)
+		require.NoError(t, err)
+		require.True(t, handled)
+	}
+	require.Equal(t, 2, len(dev.out))
+}
+
+func Test_EchoResponder_Handler(t *testing.T) {
+	r := NewEchoResponder(EchoConfig{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("10.6.7.8/32")},
+	})
+	dev := newMemDevice(1)
+
+	var passed int
+	h := r.Handler(func(dev Device, ip []byte) { passed++ })
+
+	h(dev, buildEcho(t, "10.6.7.1", "10.6.7.8", "ping"))
+	h(dev, buildEcho(t, "10.6.7.1", "10.6.7.9", "ping"))
+	require.Equal(t, 1, passed)
+	require.Equal(t, 1, len(dev.out))
+
+	r.Handler(nil)(dev, buildEcho(t, "10.6.7.1", "10.6.7.9", "ping"))
+}
+
+type ringFullDevice struct{ *memDevice }
+
+func (ringFullDevice) Alloc(size int) ([]byte, error) { return nil, ErrRingFull{} }
+
+func Test_EchoResponder_Failed(t *testing.T) {
+	r := NewEchoResponder(EchoConfig{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("10.6.7.8/32")},
+	})
+	dev := ringFullDevice{newMemDevice(1)}
+
+	var passed int
+	h := r.Handler(func(dev Device, ip []byte) { passed++ })
+
+	h(dev, buildEcho(t, "10.6.7.1", "10.6.7.8", "ping"))
+	require.Zero(t, passed)
+	require.Equal(t, uint64(1), r.Failed())
+}
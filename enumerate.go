@@ -0,0 +1,116 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"regexp"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// AdapterInfo existing wintun adapter information
+type AdapterInfo struct {
+	Name       string
+	TunType    string
+	Guid       windows.GUID
+	Luid       winipcfg.LUID
+	Index      int
+	OperStatus winipcfg.IfOperStatus
+}
+
+// Adapters list existing wintun adapters, include adapters created by other
+// process, tunType filter adapters by TunType option used when creating, empty
+// means all.
+func Adapters(tunType string) ([]AdapterInfo, error) {
+	rows, err := winipcfg.GetIfTable2Ex(winipcfg.MibIfEntryNormalWithoutStatistics)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var infos []AdapterInfo
+	for i := range rows {
+		row := &rows[i]
+		if row.Type != winipcfg.IfTypePropVirtual {
+			continue
+		}
+		typ, ok := parseTunType(row.Description())
+		if !ok || (tunType != "" && typ != tunType) {
+			continue
+		}
+		if ok, err := isAdapter(row.Alias()); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		infos = append(infos, AdapterInfo{
+			Name:       row.Alias(),
+			TunType:    typ,
+			Guid:       row.InterfaceGUID,
+			Luid:       row.InterfaceLUID,
+			Index:      int(row.InterfaceIndex),
+			OperStatus: row.OperStatus,
+		})
+	}
+	return infos, nil
+}
+
+// tunTypeExp wintun adapter description is "<TunType> Tunnel", windows
+// will add suffix " #n" for duplicate description
+var tunTypeExp = regexp.MustCompile(`^(.*) Tunnel(?: #\d+)?$`)
+
+func parseTunType(desc string) (string, bool) {
+	m := tunTypeExp.FindStringSubmatch(desc)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// isAdapter check name is wintun adapter by open it, will not start session
+func isAdapter(name string) (bool, error) {
+	name16, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	r1, _, err := syscall.SyscallN(procOpenAdapter.Addr(), uintptr(unsafe.Pointer(name16)))
+	if err := errnoErrAs(err, map[windows.Errno]error{
+		windows.ERROR_FILE_NOT_FOUND: ErrAdapterNotFound{},
+	}); err != nil {
+		if errors.Is(err, ErrAdapterNotFound{}) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, _, err = syscall.SyscallN(procCloseAdapter.Addr(), r1)
+	return true, errnoErr(err)
+}
+
+// OpenAdapterByGuid open existing adapter by interface GUID, see OpenAdapter
+func OpenAdapterByGuid(guid *windows.GUID, opts ...Option) (*Adapter, error) {
+	luid, err := winipcfg.LUIDFromGUID(guid)
+	if err := errnoErrAs(err, map[windows.Errno]error{
+		windows.ERROR_FILE_NOT_FOUND: ErrAdapterNotFound{},
+	}); err != nil {
+		return nil, err
+	}
+	return OpenAdapterByLuid(luid, opts...)
+}
+
+// OpenAdapterByLuid open existing adapter by interface LUID, see OpenAdapter
+func OpenAdapterByLuid(luid winipcfg.LUID, opts ...Option) (*Adapter, error) {
+	row, err := luid.Interface()
+	if err := errnoErrAs(err, map[windows.Errno]error{
+		windows.ERROR_FILE_NOT_FOUND: ErrAdapterNotFound{},
+	}); err != nil {
+		return nil, err
+	}
+	return OpenAdapter(row.Alias(), opts...)
+}
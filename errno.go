@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// errnoErr convert the errno returned by wintun api to typed error, return nil
+// if err is ERROR_SUCCESS
+func errnoErr(err error) error {
+	return errnoErrAs(err, nil)
+}
+
+// errnoErrAs like errnoErr, but use typed error for specified errno first, it's
+// used by api that the same errno has different meaning
+func errnoErrAs(err error, as map[windows.Errno]error) error {
+	if err == nil || err == windows.ERROR_SUCCESS {
+		return nil
+	}
+	errno, ok := err.(windows.Errno)
+	if !ok {
+		return errors.WithStack(err)
+	}
+
+	typ, ok := as[errno]
+	if !ok {
+		typ, ok = errnos[errno]
+	}
+	if ok {
+		return errors.WithStack(errnoError{err: typ, errno: errno})
+	}
+	return errors.WithStack(errno)
+}
+
+// invalidParameter typed parameter error, it also match ERROR_INVALID_PARAMETER
+func invalidParameter(err error) error {
+	return errnoError{err: err, errno: windows.ERROR_INVALID_PARAMETER}
+}
+
+var errnos = map[windows.Errno]error{
+	windows.ERROR_BUFFER_OVERFLOW:     ErrRingFull{},
+	windows.ERROR_ALREADY_INITIALIZED: ErrSessionStarted{},
+	windows.ERROR_NOT_FOUND:           ErrAdapterNotFound{},
+	windows.ERROR_ACCESS_DENIED:       ErrAccessDenied{},
+	windows.ERROR_ELEVATION_REQUIRED:  ErrAccessDenied{},
+}
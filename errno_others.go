@@ -0,0 +1,7 @@
+//go:build !windows
+// +build !windows
+
+package wintun
+
+// invalidParameter typed parameter error, no errno off windows
+func invalidParameter(err error) error { return err }
@@ -1,9 +1,18 @@
 package wintun
 
+import "fmt"
+
+// the returned errors are wrapped with stack, use errors.Is/errors.As to
+// match typed error, or get Temporary()/Timeout() by errors.As, such as:
+//
+//	var te interface{ Temporary() bool }
+//	if errors.As(err, &te) && te.Temporary() { ... }
+
 type ErrLoaded struct{}
 
 func (ErrLoaded) Error() string   { return "wintun loaded" }
 func (ErrLoaded) Temporary() bool { return true }
+func (ErrLoaded) Timeout() bool   { return false }
 
 type ErrNotLoad struct{}
 
@@ -16,3 +25,77 @@ func (ErrAdapterClosed) Error() string { return "adapter closed" }
 type ErrAdapterStoped struct{}
 
 func (ErrAdapterStoped) Error() string { return "adapter stoped" }
+
+// ErrRingFull send ring is full, the packet can be re-alloc after reader consume
+type ErrRingFull struct{}
+
+func (ErrRingFull) Error() string   { return "ring full" }
+func (ErrRingFull) Temporary() bool { return true }
+func (ErrRingFull) Timeout() bool   { return false }
+
+// ErrSessionStarted adapter session already started
+type ErrSessionStarted struct{}
+
+func (ErrSessionStarted) Error() string   { return "session already started" }
+func (ErrSessionStarted) Temporary() bool { return false }
+func (ErrSessionStarted) Timeout() bool   { return false }
+
+type ErrAdapterNotFound struct{}
+
+func (ErrAdapterNotFound) Error() string   { return "adapter not found" }
+func (ErrAdapterNotFound) Temporary() bool { return false }
+func (ErrAdapterNotFound) Timeout() bool   { return false }
+
+// ErrAccessDenied require run as administrator
+type ErrAccessDenied struct{}
+
+func (ErrAccessDenied) Error() string   { return "access denied, require elevation" }
+func (ErrAccessDenied) Temporary() bool { return false }
+func (ErrAccessDenied) Timeout() bool   { return false }
+
+type ErrDriverNotInstalled struct{}
+
+func (ErrDriverNotInstalled) Error() string   { return "wintun driver not installed" }
+func (ErrDriverNotInstalled) Temporary() bool { return false }
+func (ErrDriverNotInstalled) Timeout() bool   { return false }
+
+type ErrInvalidCapacity struct{}
+
+func (ErrInvalidCapacity) Error() string   { return "invalid ring buff capacity" }
+func (ErrInvalidCapacity) Temporary() bool { return false }
+func (ErrInvalidCapacity) Timeout() bool   { return false }
+
+// ErrInvalidMTU MTU out of range of the family
+type ErrInvalidMTU struct {
+	IPv6 bool
+	MTU  int
+}
+
+func (e ErrInvalidMTU) Error() string {
+	if e.IPv6 {
+		return fmt.Sprintf("invalid IPv6 MTU %d", e.MTU)
+	}
+	return fmt.Sprintf("invalid IPv4 MTU %d", e.MTU)
+}
+func (ErrInvalidMTU) Temporary() bool { return false }
+func (ErrInvalidMTU) Timeout() bool   { return false }
+
+// errnoError typed error with the underlying errno, both of them
+// can be matched by errors.Is
+type errnoError struct {
+	err   error
+	errno error
+}
+
+func (e errnoError) Error() string   { return e.err.Error() + ": " + e.errno.Error() }
+func (e errnoError) Unwrap() []error { return []error{e.err, e.errno} }
+
+func (e errnoError) Temporary() bool {
+	t, ok := e.err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+func (e errnoError) Timeout() bool {
+	t, ok := e.err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
@@ -0,0 +1,397 @@
+// Package forward terminate TCP/UDP flows captured from wintun adapter by a
+// userspace tcp/ip stack, and relay them through Egress, such as socks5 proxy.
+package forward
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// Egress open outbound connection for terminated flow, src is the flow
+// source address behind adapter, dst is the flow destination address.
+type Egress interface {
+	DialTCP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error)
+
+	// DialUDP return datagram-oriented conn, every Read/Write is one
+	// datagram, return ErrUnsupported if not support udp.
+	DialUDP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error)
+}
+
+type ErrUnsupported struct{}
+
+func (ErrUnsupported) Error() string { return "unsupported by egress" }
+
+// Unreachable reason of destination unreachable
+type Unreachable uint8
+
+const (
+	NetUnreachable Unreachable = iota + 1
+	HostUnreachable
+	PortUnreachable
+	AdminProhibited
+)
+
+// code get ICMP or ICMPv6 destination unreachable code
+func (u Unreachable) code(v4 bool) uint8 {
+	switch u {
+	case NetUnreachable:
+		if v4 {
+			return packet.ICMPNetUnreachable
+		}
+		return packet.ICMPv6NoRoute
+	case HostUnreachable:
+		if v4 {
+			return packet.ICMPHostUnreachable
+		}
+		return packet.ICMPv6AddressUnreachable
+	case PortUnreachable:
+		if v4 {
+			return packet.ICMPPortUnreachable
+		}
+		return packet.ICMPv6PortUnreachable
+	default:
+		if v4 {
+			return packet.ICMPAdminProhibited
+		}
+		return packet.ICMPv6AdminProhibited
+	}
+}
+
+// ErrUnreachable destination unreachable error, returned by Egress dial or
+// udp conn Read, then the ICMP/ICMPv6 destination unreachable is replied to
+// the udp flow source. the tcp flow is always reset.
+type ErrUnreachable struct {
+	Reason Unreachable
+	Err    error
+}
+
+func (e ErrUnreachable) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return "destination unreachable"
+}
+
+func (e ErrUnreachable) Unwrap() error { return e.Err }
+
+const nicID tcpip.NICID = 1
+
+type Config struct {
+	// MTU of adapter, default 1500
+	MTU int
+
+	// DialTimeout timeout of Egress dial, default 10s
+	DialTimeout time.Duration
+
+	// UDPTimeout idle timeout of udp flow, default 60s
+	UDPTimeout time.Duration
+
+	// Queue size of outbound packets queue, default 512
+	Queue int
+}
+
+func (c *Config) init() {
+	if c.MTU <= 0 {
+		c.MTU = 1500
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = time.Second * 10
+	}
+	if c.UDPTimeout <= 0 {
+		c.UDPTimeout = time.Minute
+	}
+	if c.Queue <= 0 {
+		c.Queue = 512
+	}
+}
+
+// Forwarder terminate TCP/UDP flows of packets received from adapter, and
+// relay them through Egress, the reply packets are sent by Device.
+type Forwarder struct {
+	dev    wintun.Device
+	egress Egress
+	cfg    Config
+
+	stack *stack.Stack
+	link  *channel.Endpoint
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+func New(dev wintun.Device, egress Egress, cfg Config) (*Forwarder, error) {
+	cfg.init()
+
+	var f = &Forwarder{
+		dev:    dev,
+		egress: egress,
+		cfg:    cfg,
+		stack: stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+		}),
+		link: channel.New(cfg.Queue, uint32(cfg.MTU), ""),
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	if err := f.init(); err != nil {
+		f.stack.Close()
+		return nil, err
+	}
+
+	f.wg.Add(1)
+	go f.output()
+	return f, nil
+}
+
+func (f *Forwarder) init() error {
+	if err := f.stack.CreateNIC(nicID, f.link); err != nil {
+		return errors.New(err.String())
+	}
+	// accept packets to any address, and reply from any address
+	if err := f.stack.SetPromiscuousMode(nicID, true); err != nil {
+		return errors.New(err.String())
+	}
+	if err := f.stack.SetSpoofing(nicID, true); err != nil {
+		return errors.New(err.String())
+	}
+	f.stack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	})
+
+	sack := tcpip.TCPSACKEnabled(true)
+	f.stack.SetTransportProtocolOption(tcp.ProtocolNumber, &sack)
+
+	tcpFwd := tcp.NewForwarder(f.stack, 0, 1024, f.handleTCP)
+	f.stack.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpFwd.HandlePacket)
+	udpFwd := udp.NewForwarder(f.stack, f.handleUDP)
+	f.stack.SetTransportProtocolHandler(udp.ProtocolNumber, udpFwd.HandlePacket)
+	return nil
+}
+
+// Inject inject ip packet into forwarder, return false if ip is not TCP/UDP
+// packet, the ip is copied, can be released after return.
+func (f *Forwarder) Inject(ip []byte) bool {
+	var proto tcpip.NetworkProtocolNumber
+	switch packet.Version(ip) {
+	case 4:
+		if len(ip) < packet.IPv4MinSize {
+			return false
+		}
+		proto = header.IPv4ProtocolNumber
+		if p := ip[9]; p != packet.TCP && p != packet.UDP {
+			return false
+		}
+	case 6:
+		info, err := packet.Parse(ip)
+		if err != nil || (info.Proto != packet.TCP && info.Proto != packet.UDP) {
+			return false
+		}
+		proto = header.IPv6ProtocolNumber
+	default:
+		return false
+	}
+
+	pkb := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(ip),
+	})
+	f.link.InjectInbound(proto, pkb)
+	pkb.DecRef()
+	return true
+}
+
+// Handler wrap next handler, the TCP/UDP packets are injected into forwarder,
+// others are passed to next, next can be nil.
+func (f *Forwarder) Handler(next wintun.Handler) wintun.Handler {
+	return func(dev wintun.Device, ip []byte) {
+		if !f.Inject(ip) && next != nil {
+			next(dev, ip)
+		}
+	}
+}
+
+// Serve receive packets from Device and forward them, blocks until ctx done
+// or Device Recv failed, return nil if ctx done.
+func (f *Forwarder) Serve(ctx context.Context) error {
+	return wintun.Dispatch(ctx, f.dev, f.Handler(nil), wintun.DispatchConfig{})
+}
+
+// output send the packets output by stack to Device
+func (f *Forwarder) output() {
+	defer f.wg.Done()
+	for {
+		pkb := f.link.ReadContext(f.ctx)
+		if pkb.IsNil() {
+			return
+		}
+
+		p, err := f.dev.Alloc(pkb.Size())
+		if err == nil {
+			n := 0
+			for _, s := range pkb.AsSlices() {
+				n += copy(p[n:], s)
+			}
+			err = f.dev.Send(p)
+		}
+		pkb.DecRef()
+
+		if err != nil && !errors.Is(err, wintun.ErrRingFull{}) {
+			f.close(err)
+			return
+		}
+	}
+}
+
+func (f *Forwarder) handleTCP(r *tcp.ForwarderRequest) {
+	id := r.ID()
+	src, dst := addrPort(id.RemoteAddress, id.RemotePort), addrPort(id.LocalAddress, id.LocalPort)
+
+	if !f.add() {
+		r.Complete(true)
+		return
+	}
+	defer f.wg.Done()
+
+	ctx, cancel := context.WithTimeout(f.ctx, f.cfg.DialTimeout)
+	conn, err := f.egress.DialTCP(ctx, src, dst)
+	cancel()
+	if err != nil {
+		r.Complete(true)
+		return
+	}
+
+	var wq waiter.Queue
+	ep, terr := r.CreateEndpoint(&wq)
+	if terr != nil {
+		conn.Close()
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+	ep.SocketOptions().SetKeepAlive(true)
+
+	relayTCP(f.ctx, gonet.NewTCPConn(&wq, ep), conn)
+}
+
+func (f *Forwarder) handleUDP(r *udp.ForwarderRequest) {
+	id := r.ID()
+	src, dst := addrPort(id.RemoteAddress, id.RemotePort), addrPort(id.LocalAddress, id.LocalPort)
+
+	var wq waiter.Queue
+	ep, terr := r.CreateEndpoint(&wq)
+	if terr != nil {
+		return
+	}
+	local := gonet.NewUDPConn(f.stack, &wq, ep)
+	if !f.add() {
+		local.Close()
+		return
+	}
+
+	go func() {
+		defer f.wg.Done()
+
+		ctx, cancel := context.WithTimeout(f.ctx, f.cfg.DialTimeout)
+		conn, err := f.egress.DialUDP(ctx, src, dst)
+		cancel()
+		if err != nil {
+			local.Close()
+		} else {
+			err = relayUDP(f.ctx, local, conn, f.cfg.UDPTimeout)
+		}
+		f.unreachable(src, dst, err)
+	}()
+}
+
+// unreachable reply destination unreachable to udp flow source if err is
+// ErrUnreachable, the invoking packet is reconstructed from flow addresses
+func (f *Forwarder) unreachable(src, dst netip.AddrPort, err error) {
+	var e ErrUnreachable
+	if !errors.As(err, &e) || f.ctx.Err() != nil {
+		return
+	}
+
+	var (
+		h    = packet.IP{Src: src.Addr(), Dst: dst.Addr()}
+		udp  = &packet.UDPSegment{SrcPort: src.Port(), DstPort: dst.Port()}
+		orig = make([]byte, packet.IPv6Size+packet.UDPSize)
+	)
+	n, err := packet.Build(orig, h, udp)
+	if err != nil {
+		return
+	}
+	wintun.Inject(f.dev,
+		packet.IP{Src: dst.Addr(), Dst: src.Addr()},
+		&packet.Unreachable{Code: e.Reason.code(src.Addr().Is4()), Data: orig[:n]},
+	)
+}
+
+// add register a flow goroutine, return false if closed
+func (f *Forwarder) add() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.wg.Add(1)
+	return true
+}
+
+func (f *Forwarder) close(cause error) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return f.err
+	}
+	f.closed = true
+	f.err = cause
+	f.mu.Unlock()
+
+	f.cancel()
+	f.stack.Close()
+	return cause
+}
+
+// Err get the error that caused forwarder closed
+func (f *Forwarder) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close close forwarder and all relaying flows
+func (f *Forwarder) Close() error {
+	err := f.close(nil)
+	f.wg.Wait()
+	f.stack.Wait()
+	return err
+}
+
+func addrPort(addr tcpip.Address, port uint16) netip.AddrPort {
+	a, _ := netip.AddrFromSlice(addr.AsSlice())
+	return netip.AddrPortFrom(a, port)
+}
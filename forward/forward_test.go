@@ -0,0 +1,175 @@
+package forward_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/internal/stacktest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	host4   = netip.MustParseAddr("10.6.7.8")
+	host6   = netip.MustParseAddr("fd00::8")
+	remote4 = netip.MustParseAddrPort("203.0.113.1:80")
+	remote6 = netip.MustParseAddrPort("[2001:db8::1]:80")
+)
+
+// egress dial the local echo servers, regardless of flow destination
+type egress struct {
+	tcp, udp string
+
+	mu    sync.Mutex
+	flows [][2]netip.AddrPort
+}
+
+func (e *egress) record(src, dst netip.AddrPort) {
+	e.mu.Lock()
+	e.flows = append(e.flows, [2]netip.AddrPort{src, dst})
+	e.mu.Unlock()
+}
+
+func (e *egress) DialTCP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	e.record(src, dst)
+	if dst.Port() != 80 {
+		return nil, errors.New("refused")
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", e.tcp)
+}
+
+func (e *egress) DialUDP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	e.record(src, dst)
+	var d net.Dialer
+	return d.DialContext(ctx, "udp", e.udp)
+}
+
+func echoServers(t *testing.T) (tcp, udp string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+
+	u, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { u.Close() })
+	go func() {
+		var b = make([]byte, 0xffff)
+		for {
+			n, addr, err := u.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			u.WriteTo(b[:n], addr)
+		}
+	}()
+	return l.Addr().String(), u.LocalAddr().String()
+}
+
+func setup(t *testing.T, e forward.Egress) *stacktest.Host {
+	host, err := stacktest.New(1500, host4, host6)
+	require.NoError(t, err)
+
+	f, err := forward.New(host, e, forward.Config{UDPTimeout: time.Second})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done = make(chan error, 1)
+	go func() { done <- f.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+		require.NoError(t, f.Close())
+		require.NoError(t, host.Close())
+	})
+	return host
+}
+
+func Test_Forward_TCP(t *testing.T) {
+	tcp, udp := echoServers(t)
+	e := &egress{tcp: tcp, udp: udp}
+	host := setup(t, e)
+
+	for _, dst := range []netip.AddrPort{remote4, remote6} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		conn, err := host.DialTCP(ctx, dst)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		var msg = make([]byte, 64*1024)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		go func() {
+			conn.Write(msg)
+			conn.(interface{ CloseWrite() error }).CloseWrite()
+		}()
+
+		// half-close propagated, echo server close after EOF
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, msg, got)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	require.Len(t, e.flows, 2)
+	require.Equal(t, host4, e.flows[0][0].Addr())
+	require.Equal(t, remote4, e.flows[0][1])
+	require.Equal(t, host6, e.flows[1][0].Addr())
+	require.Equal(t, remote6, e.flows[1][1])
+}
+
+func Test_Forward_TCP_Refused(t *testing.T) {
+	tcp, udp := echoServers(t)
+	host := setup(t, &egress{tcp: tcp, udp: udp})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := host.DialTCP(ctx, netip.AddrPortFrom(remote4.Addr(), 81))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "refused")
+}
+
+func Test_Forward_UDP(t *testing.T) {
+	tcp, udp := echoServers(t)
+	host := setup(t, &egress{tcp: tcp, udp: udp})
+
+	for _, dst := range []netip.AddrPort{remote4, remote6} {
+		conn, err := host.DialUDP(dst)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		for i := 0; i < 3; i++ {
+			_, err = conn.Write([]byte("hello"))
+			require.NoError(t, err)
+
+			var b = make([]byte, 64)
+			conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+			n, err := conn.Read(b)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(b[:n]))
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// relayTCP copy data between a and b until both directions finished, the
+// EOF of one direction is propagated by half-close if supported. a and b are
+// closed after return.
+func relayTCP(ctx context.Context, a, b net.Conn) {
+	stop := context.AfterFunc(ctx, func() {
+		a.Close()
+		b.Close()
+	})
+	defer stop()
+	defer a.Close()
+	defer b.Close()
+
+	var wg sync.WaitGroup
+	half := func(dst, src net.Conn) {
+		defer wg.Done()
+		_, err := io.Copy(dst, src)
+		if cw, ok := dst.(closeWriter); ok && err == nil {
+			cw.CloseWrite()
+		} else {
+			// can't propagate EOF, or copy failed
+			dst.Close()
+			src.Close()
+		}
+	}
+	wg.Add(2)
+	go half(a, b)
+	go half(b, a)
+	wg.Wait()
+}
+
+// relayUDP copy datagrams between local and remote, until idle timeout, local
+// and remote are closed after return. return the error of remote Read, nil if
+// idle timeout or terminated by local.
+func relayUDP(ctx context.Context, local, remote net.Conn, idle time.Duration) (err error) {
+	stop := context.AfterFunc(ctx, func() {
+		local.Close()
+		remote.Close()
+	})
+	defer stop()
+	defer local.Close()
+	defer remote.Close()
+
+	var last atomic.Int64
+	last.Store(time.Now().UnixNano())
+
+	var closed atomic.Bool
+
+	var wg sync.WaitGroup
+	half := func(dst, src net.Conn) {
+		defer wg.Done()
+
+		var b = make([]byte, 0xffff)
+		for {
+			// the deadline is refreshed by any direction activity
+			deadline := time.Unix(0, last.Load()).Add(idle)
+			src.SetReadDeadline(deadline)
+
+			n, e := src.Read(b)
+			if e != nil {
+				ne, ok := e.(net.Error)
+				if ok && ne.Timeout() &&
+					time.Since(time.Unix(0, last.Load())) < idle {
+					continue
+				}
+				if closed.CompareAndSwap(false, true) {
+					if src == remote && !(ok && ne.Timeout()) {
+						err = e
+					}
+					dst.Close()
+					src.Close()
+				}
+				return
+			}
+			last.Store(time.Now().UnixNano())
+			if _, err := dst.Write(b[:n]); err != nil {
+				if closed.CompareAndSwap(false, true) {
+					dst.Close()
+					src.Close()
+				}
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go half(local, remote)
+	go half(remote, local)
+	wg.Wait()
+	return err
+}
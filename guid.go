@@ -0,0 +1,96 @@
+package wintun
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GUID has the same layout as windows.GUID, can be converted directly
+type GUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// DeriveGuid derive RFC 4122 version 5 GUID from namespace and name, the same
+// input always get the same GUID, so windows will not create new network profile
+// for adapter on each run.
+func DeriveGuid(namespace GUID, name string) GUID {
+	h := sha1.New()
+	h.Write(namespace.bytes())
+	h.Write([]byte(name))
+	sum := h.Sum(nil)
+
+	sum[6] = (sum[6] & 0x0f) | 0x50 // version 5
+	sum[8] = (sum[8] & 0x3f) | 0x80 // variant RFC 4122
+	return guidFromBytes(sum[:16])
+}
+
+// ParseGuid parse GUID like "{6BA7B810-9DAD-11D1-80B4-00C04FD430C8}", the
+// braces are optional and case insensitive
+func ParseGuid(s string) (GUID, error) {
+	str := strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	if len(str) != 36 || len(s)-len(str) == 1 {
+		return GUID{}, errors.Errorf("invalid GUID %q", s)
+	}
+
+	var b = make([]byte, 0, 16)
+	for i, n := range []int{8, 4, 4, 4, 12} {
+		if i > 0 {
+			if str[0] != '-' {
+				return GUID{}, errors.Errorf("invalid GUID %q", s)
+			}
+			str = str[1:]
+		}
+		v, err := hex.DecodeString(str[:n])
+		if err != nil {
+			return GUID{}, errors.Errorf("invalid GUID %q", s)
+		}
+		b, str = append(b, v...), str[n:]
+	}
+	return guidFromBytes(b), nil
+}
+
+// MustParseGuid like ParseGuid but panic if error
+func MustParseGuid(s string) GUID {
+	g, err := ParseGuid(s)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// String format GUID like windows.GUID.String
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%02X%02X-%02X%02X%02X%02X%02X%02X}",
+		g.Data1, g.Data2, g.Data3,
+		g.Data4[0], g.Data4[1], g.Data4[2], g.Data4[3],
+		g.Data4[4], g.Data4[5], g.Data4[6], g.Data4[7],
+	)
+}
+
+// bytes RFC 4122 network byte order
+func (g GUID) bytes() []byte {
+	var b = make([]byte, 16)
+	binary.BigEndian.PutUint32(b[0:], g.Data1)
+	binary.BigEndian.PutUint16(b[4:], g.Data2)
+	binary.BigEndian.PutUint16(b[6:], g.Data3)
+	copy(b[8:], g.Data4[:])
+	return b
+}
+
+func guidFromBytes(b []byte) GUID {
+	var g = GUID{
+		Data1: binary.BigEndian.Uint32(b[0:]),
+		Data2: binary.BigEndian.Uint16(b[4:]),
+		Data3: binary.BigEndian.Uint16(b[6:]),
+	}
+	copy(g.Data4[:], b[8:16])
+	return g
+}
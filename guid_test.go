@@ -0,0 +1,47 @@
+package wintun_test
+
+import (
+	"testing"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DeriveGuid(t *testing.T) {
+	// python: uuid.uuid5(uuid.NAMESPACE_DNS, "python.org")
+	ns := wintun.MustParseGuid("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	g := wintun.DeriveGuid(ns, "python.org")
+	require.Equal(t, "{886313E1-3B8A-5372-9B90-0C9AEE199E5D}", g.String())
+
+	require.Equal(t, g, wintun.DeriveGuid(ns, "python.org"))
+	require.NotEqual(t, g, wintun.DeriveGuid(ns, "python.com"))
+}
+
+func Test_ParseGuid(t *testing.T) {
+	t.Run("format", func(t *testing.T) {
+		for _, s := range []string{
+			"{886313E1-3B8A-5372-9B90-0C9AEE199E5D}",
+			"886313E1-3B8A-5372-9B90-0C9AEE199E5D",
+			"886313e1-3b8a-5372-9b90-0c9aee199e5d",
+		} {
+			g, err := wintun.ParseGuid(s)
+			require.NoError(t, err)
+			require.Equal(t, "{886313E1-3B8A-5372-9B90-0C9AEE199E5D}", g.String())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"{886313E1-3B8A-5372-9B90-0C9AEE199E5D",
+			"886313E1-3B8A-5372-9B90-0C9AEE199E5D}",
+			"886313E1-3B8A-5372-9B90+0C9AEE199E5D",
+			"886313E1-3B8A-5372-9B90-0C9AEE199E5Z",
+			"886313E13B8A53729B900C9AEE199E5D",
+		} {
+			_, err := wintun.ParseGuid(s)
+			require.Error(t, err, s)
+		}
+	})
+}
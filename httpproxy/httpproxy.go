@@ -0,0 +1,160 @@
+// Package httpproxy HTTP/1.1 CONNECT proxy egress, relay the TCP flows
+// terminated by forward package through http proxy, the destinations match
+// bypass rules are dialed directly.
+package httpproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/internal/bind"
+	"github.com/pkg/errors"
+)
+
+type ErrAuth struct{}
+
+func (ErrAuth) Error() string { return "http proxy authentication required" }
+
+// ErrStatus http proxy response not success status
+type ErrStatus struct{ Code int }
+
+func (e ErrStatus) Error() string {
+	return fmt.Sprintf("http proxy response %d %s", e.Code, http.StatusText(e.Code))
+}
+
+// Dialer *net.Dialer implement it
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Client http CONNECT proxy client, it implement forward.Egress, udp flows
+// only supported when bypassed.
+type Client struct {
+	// Proxy http proxy address, host:port
+	Proxy string
+
+	// Username and Password for basic authentication, empty Username means
+	// no authentication
+	Username, Password string
+
+	// Bypass the destinations match any rule are dialed directly
+	Bypass []Rule
+
+	// Dialer used to dial proxy and bypassed destinations, default *net.Dialer
+	// bound to Interface. when adapter override default route, it must bind
+	// physical interface, otherwise the connections are routed back into
+	// adapter.
+	Dialer Dialer
+
+	// Interface index of physical interface, used when Dialer is nil, the
+	// proxy and bypassed destinations are dialed bound to it, 0 means
+	// not bind.
+	Interface int
+}
+
+var _ forward.Egress = (*Client)(nil)
+
+func (c *Client) dialer() Dialer {
+	if c.Dialer != nil {
+		return c.Dialer
+	} else if c.Interface != 0 {
+		return bind.Dialer(c.Interface)
+	}
+	return &net.Dialer{}
+}
+
+func (c *Client) bypass(dst netip.AddrPort) bool {
+	for _, r := range c.Bypass {
+		if r.Match(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) DialTCP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	if c.bypass(dst) {
+		conn, err := c.dialer().DialContext(ctx, "tcp", dst.String())
+		return conn, errors.WithStack(err)
+	}
+
+	conn, err := c.dialer().DialContext(ctx, "tcp", c.Proxy)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	br, err := c.connect(conn, dst)
+	if !stop() {
+		// ctx done while connecting
+		err = errors.WithStack(ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if br.Buffered() > 0 {
+		return &bufConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// DialUDP dial dst directly if bypassed, otherwise return forward.ErrUnsupported
+func (c *Client) DialUDP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	if !c.bypass(dst) {
+		return nil, errors.WithStack(forward.ErrUnsupported{})
+	}
+	conn, err := c.dialer().DialContext(ctx, "udp", dst.String())
+	return conn, errors.WithStack(err)
+}
+
+func (c *Client) connect(conn net.Conn, dst netip.AddrPort) (*bufio.Reader, error) {
+	var b = []byte("CONNECT " + dst.String() + " HTTP/1.1\r\nHost: " + dst.String() + "\r\n")
+	if c.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Password))
+		b = append(b, "Proxy-Authorization: Basic "+auth+"\r\n"...)
+	}
+	b = append(b, "\r\n"...)
+	if _, err := conn.Write(b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, errors.WithStack(ErrAuth{})
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, errors.WithStack(ErrStatus{Code: resp.StatusCode})
+	}
+	return br, nil
+}
+
+// bufConn conn with the data buffered when reading CONNECT response
+type bufConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *bufConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
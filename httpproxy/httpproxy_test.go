@@ -0,0 +1,270 @@
+package httpproxy_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/httpproxy"
+	"github.com/lysShub/wintun-go/internal/stacktest"
+	"github.com/stretchr/testify/require"
+)
+
+// proxy in-process http CONNECT proxy, all tunnels are redirected to the
+// local echo server, the requested destinations are recorded
+type proxy struct {
+	auth string
+	echo string
+
+	mu  sync.Mutex
+	dst []string
+}
+
+func newProxy(t *testing.T, username, password string) (*proxy, string) {
+	var p = &proxy{echo: echoServer(t)}
+	if username != "" {
+		p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: p}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return p, l.Addr().String()
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if p.auth != "" && r.Header.Get("Proxy-Authorization") != p.auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+	if _, port, _ := net.SplitHostPort(r.Host); port == "81" {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	p.mu.Lock()
+	p.dst = append(p.dst, r.Host)
+	p.mu.Unlock()
+
+	target, err := net.Dial("tcp", p.echo)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// response with tunnel data in the same write
+	rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+	rw.Flush()
+
+	go func() {
+		io.Copy(target, rw)
+		target.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(conn, target)
+}
+
+func (p *proxy) recorded() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.dst...)
+}
+
+func echoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dialer redirect the bypassed destinations to local echo server
+type dialer struct {
+	proxy, echo string
+
+	mu     sync.Mutex
+	direct []string
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if address != d.proxy {
+		d.mu.Lock()
+		d.direct = append(d.direct, network+"://"+address)
+		d.mu.Unlock()
+		address = d.echo
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, address)
+}
+
+var (
+	src  = netip.MustParseAddrPort("10.6.7.8:1234")
+	dst4 = netip.MustParseAddrPort("203.0.113.1:80")
+	dst6 = netip.MustParseAddrPort("[2001:db8::1]:443")
+)
+
+func echo(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	var b = make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+func Test_Client(t *testing.T) {
+	p, addr := newProxy(t, "user", "pass")
+	c := &httpproxy.Client{Proxy: addr, Username: "user", Password: "pass"}
+	ctx := context.Background()
+
+	t.Run("connect", func(t *testing.T) {
+		for _, dst := range []netip.AddrPort{dst4, dst6} {
+			conn, err := c.DialTCP(ctx, src, dst)
+			require.NoError(t, err)
+			echo(t, conn)
+			conn.Close()
+		}
+		require.Equal(t, []string{dst4.String(), dst6.String()}, p.recorded())
+	})
+
+	t.Run("auth-fail", func(t *testing.T) {
+		c := &httpproxy.Client{Proxy: addr, Username: "user", Password: "wrong"}
+		_, err := c.DialTCP(ctx, src, dst4)
+		require.ErrorIs(t, err, httpproxy.ErrAuth{})
+	})
+
+	t.Run("bad-gateway", func(t *testing.T) {
+		_, err := c.DialTCP(ctx, src, netip.AddrPortFrom(dst4.Addr(), 81))
+		require.ErrorIs(t, err, httpproxy.ErrStatus{Code: http.StatusBadGateway})
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		_, err := c.DialUDP(ctx, src, dst4)
+		require.ErrorIs(t, err, forward.ErrUnsupported{})
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		ctx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+		defer cancel()
+		c := &httpproxy.Client{Proxy: l.Addr().String()}
+		_, err = c.DialTCP(ctx, src, dst4)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func Test_Client_Bypass(t *testing.T) {
+	p, addr := newProxy(t, "", "")
+	rules, err := httpproxy.ParseBypass("198.51.100.0/24, [2001:db8::1]:443")
+	require.NoError(t, err)
+
+	d := &dialer{proxy: addr, echo: p.echo}
+	c := &httpproxy.Client{Proxy: addr, Bypass: rules, Dialer: d}
+	ctx := context.Background()
+
+	for _, dst := range []string{"198.51.100.7:22", "[2001:db8::1]:443", "[2001:db8::1]:80"} {
+		conn, err := c.DialTCP(ctx, src, netip.MustParseAddrPort(dst))
+		require.NoError(t, err)
+		echo(t, conn)
+		conn.Close()
+	}
+
+	_, err = c.DialUDP(ctx, src, netip.MustParseAddrPort("198.51.100.7:53"))
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"[2001:db8::1]:80"}, p.recorded())
+	require.Equal(t, []string{
+		"tcp://198.51.100.7:22", "tcp://[2001:db8::1]:443", "udp://198.51.100.7:53",
+	}, d.direct)
+}
+
+func Test_Client_Interface(t *testing.T) {
+	p, addr := newProxy(t, "", "")
+	ctx := context.Background()
+
+	// bind to invalid interface
+	c := &httpproxy.Client{Proxy: addr, Interface: 1 << 20}
+	_, err := c.DialTCP(ctx, src, dst4)
+	require.Error(t, err)
+	require.Empty(t, p.recorded())
+
+	if os.Geteuid() != 0 {
+		t.Skip("require root")
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("not loopback interface")
+	}
+	c = &httpproxy.Client{Proxy: addr, Interface: lo.Index}
+	conn, err := c.DialTCP(ctx, src, dst4)
+	require.NoError(t, err)
+	defer conn.Close()
+	echo(t, conn)
+}
+
+func Test_Forward(t *testing.T) {
+	p, addr := newProxy(t, "user", "pass")
+
+	host, err := stacktest.New(1500, src.Addr())
+	require.NoError(t, err)
+	defer host.Close()
+
+	f, err := forward.New(host, &httpproxy.Client{Proxy: addr, Username: "user", Password: "pass"}, forward.Config{})
+	require.NoError(t, err)
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Serve(ctx)
+
+	dctx, dcancel := context.WithTimeout(ctx, time.Second*5)
+	defer dcancel()
+	conn, err := host.DialTCP(dctx, dst4)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg := make([]byte, 32*1024)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	go func() {
+		conn.Write(msg)
+		conn.(interface{ CloseWrite() error }).CloseWrite()
+	}()
+	got, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, msg, got)
+	require.Equal(t, []string{dst4.String()}, p.recorded())
+}
@@ -0,0 +1,97 @@
+package httpproxy
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Rule bypass rule, match destination in Prefix, and with Port if not zero
+type Rule struct {
+	Prefix netip.Prefix
+	Port   uint16
+}
+
+func (r Rule) Match(dst netip.AddrPort) bool {
+	return r.Prefix.Contains(dst.Addr().Unmap()) && (r.Port == 0 || r.Port == dst.Port())
+}
+
+func (r Rule) String() string {
+	var s = r.Prefix.String()
+	if r.Prefix.IsSingleIP() {
+		s = r.Prefix.Addr().String()
+	}
+	if r.Port == 0 {
+		return s
+	} else if r.Prefix.Addr().Is6() {
+		s = "[" + s + "]"
+	}
+	return s + ":" + strconv.Itoa(int(r.Port))
+}
+
+// ParseBypass parse comma separated bypass rules, the rule can be address,
+// prefix, address:port or prefix:port, IPv6 with port must be bracketed, such
+// as "10.0.0.0/8, 192.168.1.1:443, [fd00::/8]:53"
+func ParseBypass(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		r, err := parseRule(e)
+		if err != nil {
+			return nil, errors.Errorf("invalid bypass rule %q", e)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func parseRule(s string) (r Rule, err error) {
+	var (
+		host, port = s, ""
+		hasPort    bool
+	)
+	if strings.HasPrefix(s, "[") {
+		i := strings.Index(s, "]")
+		if i < 0 {
+			return Rule{}, errors.New("missing ']'")
+		}
+		host = s[1:i]
+		if s[i+1:] != "" {
+			port, hasPort = strings.CutPrefix(s[i+1:], ":")
+			if !hasPort {
+				return Rule{}, errors.New("missing port")
+			}
+		}
+	} else if strings.Count(s, ":") == 1 {
+		host, port, hasPort = strings.Cut(s, ":")
+	}
+
+	if hasPort {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || p == 0 {
+			return Rule{}, errors.Errorf("invalid port %q", port)
+		}
+		r.Port = uint16(p)
+	}
+
+	if strings.Contains(host, "/") {
+		prefix, err := netip.ParsePrefix(host)
+		if err != nil {
+			return Rule{}, errors.WithStack(err)
+		}
+		r.Prefix = prefix.Masked()
+	} else {
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			return Rule{}, errors.WithStack(err)
+		}
+		addr = addr.Unmap()
+		r.Prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return r, nil
+}
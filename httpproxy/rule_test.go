@@ -0,0 +1,50 @@
+package httpproxy_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/wintun-go/httpproxy"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseBypass(t *testing.T) {
+	rules, err := httpproxy.ParseBypass("10.0.0.0/8, 192.168.1.1:443,,fd00::1, [fd00::/8]:53, [2001:db8::1]")
+	require.NoError(t, err)
+
+	var strs []string
+	for _, r := range rules {
+		strs = append(strs, r.String())
+	}
+	require.Equal(t, []string{
+		"10.0.0.0/8", "192.168.1.1:443", "fd00::1", "[fd00::/8]:53", "2001:db8::1",
+	}, strs)
+
+	for _, e := range []struct {
+		dst   string
+		match bool
+	}{
+		{"10.1.2.3:80", true},
+		{"11.1.2.3:80", false},
+		{"192.168.1.1:443", true},
+		{"192.168.1.1:80", false},
+		{"[::ffff:192.168.1.1]:443", true},
+		{"[fd00::1]:80", true},
+		{"[fd12::1]:53", true},
+		{"[fd12::1]:80", false},
+	} {
+		dst := netip.MustParseAddrPort(e.dst)
+		var match bool
+		for _, r := range rules {
+			match = match || r.Match(dst)
+		}
+		require.Equal(t, e.match, match, e.dst)
+	}
+
+	for _, s := range []string{
+		"10.0.0.0/33", "10.0.0.1:", "10.0.0.1:0", "10.0.0.1:65536", "[fd00::1", "[fd00::1]53", "example.com",
+	} {
+		_, err := httpproxy.ParseBypass(s)
+		require.Error(t, err, s)
+	}
+}
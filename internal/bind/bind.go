@@ -0,0 +1,25 @@
+// Package bind dial sockets bound to interface, used by egress to avoid the
+// connections routed back into adapter.
+package bind
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Dialer get dialer that bind sockets to interface by index
+func Dialer(index int) *net.Dialer {
+	return &net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if e := c.Control(func(fd uintptr) {
+				err = bindInterface(fd, network, index)
+			}); e != nil {
+				return errors.WithStack(e)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package bind
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+func bindInterface(fd uintptr, network string, index int) error {
+	ifi, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifi.Name)
+	return errors.WithStack(err)
+}
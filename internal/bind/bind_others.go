@@ -0,0 +1,14 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package bind
+
+import (
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+func bindInterface(fd uintptr, network string, index int) error {
+	return errors.Errorf("not support bind interface on %s", runtime.GOOS)
+}
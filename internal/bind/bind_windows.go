@@ -0,0 +1,32 @@
+//go:build windows
+// +build windows
+
+package bind
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/winsock/ipproto-ip-socket-options
+const (
+	ipUnicastIf   = 31
+	ipv6UnicastIf = 31
+)
+
+func bindInterface(fd uintptr, network string, index int) error {
+	if strings.HasSuffix(network, "6") {
+		err := windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, ipv6UnicastIf, index)
+		return errors.WithStack(err)
+	}
+
+	// IPv4 interface index is in network byte order
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(index))
+	idx := binary.NativeEndian.Uint32(b[:])
+	err := windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, ipUnicastIf, int(idx))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,143 @@
+// Package stacktest userspace tcp/ip stack as the host behind a fake Device,
+// used to test the packet processing on platforms without wintun adapter.
+package stacktest
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/pkg/errors"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const nicID tcpip.NICID = 1
+
+// Host userspace host with addrs, the packets sent by host can be received
+// from Device, and the packets sent to Device are delivered to host.
+type Host struct {
+	stack *stack.Stack
+	link  *channel.Endpoint
+
+	closed atomic.Bool
+}
+
+var _ wintun.Device = (*Host)(nil)
+
+func New(mtu int, addrs ...netip.Addr) (*Host, error) {
+	var h = &Host{
+		stack: stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4, icmp.NewProtocol6},
+		}),
+		link: channel.New(512, uint32(mtu), ""),
+	}
+	if err := h.stack.CreateNIC(nicID, h.link); err != nil {
+		return nil, errors.New(err.String())
+	}
+	for _, addr := range addrs {
+		proto := ipv4.ProtocolNumber
+		if addr.Is6() {
+			proto = ipv6.ProtocolNumber
+		}
+		err := h.stack.AddProtocolAddress(nicID, tcpip.ProtocolAddress{
+			Protocol:          proto,
+			AddressWithPrefix: tcpip.AddrFromSlice(addr.AsSlice()).WithPrefix(),
+		}, stack.AddressProperties{})
+		if err != nil {
+			return nil, errors.New(err.String())
+		}
+	}
+	h.stack.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	})
+	return h, nil
+}
+
+// Stack underlying stack
+func (h *Host) Stack() *stack.Stack { return h.stack }
+
+func (h *Host) DialTCP(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+	return gonet.DialContextTCP(ctx, h.stack, fullAddr(dst), protocol(dst.Addr()))
+}
+
+func (h *Host) DialUDP(dst netip.AddrPort) (net.Conn, error) {
+	raddr := fullAddr(dst)
+	return gonet.DialUDP(h.stack, nil, &raddr, protocol(dst.Addr()))
+}
+
+func (h *Host) Recv(ctx context.Context) ([]byte, error) {
+	pkb := h.link.ReadContext(ctx)
+	if pkb.IsNil() {
+		if h.closed.Load() {
+			return nil, errors.WithStack(wintun.ErrAdapterClosed{})
+		}
+		return nil, errors.WithStack(ctx.Err())
+	}
+	defer pkb.DecRef()
+
+	var ip = make([]byte, 0, pkb.Size())
+	for _, s := range pkb.AsSlices() {
+		ip = append(ip, s...)
+	}
+	return ip, nil
+}
+
+func (h *Host) Release(ip []byte) error { return nil }
+
+func (h *Host) Alloc(size int) ([]byte, error) {
+	if h.closed.Load() {
+		return nil, errors.WithStack(wintun.ErrAdapterClosed{})
+	}
+	return make([]byte, size), nil
+}
+
+func (h *Host) Send(ip []byte) error {
+	proto := ipv4.ProtocolNumber
+	if header.IPVersion(ip) == header.IPv6Version {
+		proto = ipv6.ProtocolNumber
+	}
+	pkb := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(ip),
+	})
+	h.link.InjectInbound(proto, pkb)
+	pkb.DecRef()
+	return nil
+}
+
+func (h *Host) Close() error {
+	if h.closed.CompareAndSwap(false, true) {
+		h.link.Close()
+		h.stack.Close()
+		h.stack.Wait()
+	}
+	return nil
+}
+
+func fullAddr(addr netip.AddrPort) tcpip.FullAddress {
+	return tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.AddrFromSlice(addr.Addr().AsSlice()),
+		Port: addr.Port(),
+	}
+}
+
+func protocol(addr netip.Addr) tcpip.NetworkProtocolNumber {
+	if addr.Is4() {
+		return ipv4.ProtocolNumber
+	}
+	return ipv6.ProtocolNumber
+}
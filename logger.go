@@ -3,11 +3,12 @@ package wintun
 import (
 	"context"
 	"log/slog"
-	"runtime"
-	"syscall"
-
-	"github.com/pkg/errors"
-	"golang.org/x/sys/windows"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+	"unsafe"
 )
 
 type LoggerLevel int
@@ -18,51 +19,173 @@ const (
 	Error
 )
 
+// Level implement slog.Leveler
+func (l LoggerLevel) Level() slog.Level {
+	switch l {
+	case Info:
+		return slog.LevelInfo
+	case Warn:
+		return slog.LevelWarn
+	case Error:
+		return slog.LevelError
+	default:
+		return slog.LevelDebug
+	}
+}
+
 type LoggerCallback func(level LoggerLevel, timestamp uint64, msg *uint16) uintptr
 
-func DefaultCallback(log *slog.Logger) LoggerCallback {
+// DefaultCallback log wintun message by log, see HandlerCallback
+func DefaultCallback(log *slog.Logger, opts ...LoggerOption) LoggerCallback {
+	return HandlerCallback(log.Handler(), opts...)
+}
+
+// HandlerCallback log wintun message by slog.Handler, the record time is the
+// wintun timestamp, and the attribute "adapter" will be added if the adapter
+// name can be parsed from message
+func HandlerCallback(h slog.Handler, opts ...LoggerOption) LoggerCallback {
+	l := newLogger(h, opts...)
 	return func(level LoggerLevel, timestamp uint64, msg *uint16) uintptr {
-		var sl slog.Level
-		switch level {
-		case Info:
-			sl = slog.LevelInfo
-		case Warn:
-			sl = slog.LevelWarn
-		case Error:
-			sl = slog.LevelError
-		default:
-			sl = slog.LevelDebug
-		}
-		log.LogAttrs(
-			context.Background(), sl,
-			windows.UTF16PtrToString(msg),
-		)
+		l.log(level, timestamp, utf16PtrToString(msg))
 		return 0
 	}
 }
 
-func SetLogger(logger LoggerCallback) error {
-	var callback uintptr
-	if logger != nil {
-		switch runtime.GOARCH {
-		case "386":
-			callback = windows.NewCallback(func(level LoggerLevel, timestampLow, timestampHigh uint32, msg *uint16) {
-				logger(level, uint64(timestampHigh)<<32|uint64(timestampLow), msg)
-			})
-		case "arm":
-			callback = windows.NewCallback(func(level LoggerLevel, _, timestampLow, timestampHigh uint32, msg *uint16) {
-				logger(level, uint64(timestampHigh)<<32|uint64(timestampLow), msg)
-			})
-		case "amd64", "arm64":
-			callback = windows.NewCallback(logger)
-		default:
-			return errors.Errorf("not support windows arch %s", runtime.GOARCH)
+type logger struct {
+	h    slog.Handler
+	opts *loggerOptions
+
+	mu   sync.Mutex
+	seen map[logKey]*logSeen
+}
+
+type logKey struct {
+	level LoggerLevel
+	msg   string
+}
+
+type logSeen struct {
+	last       time.Time
+	suppressed int
+}
+
+func newLogger(h slog.Handler, opts ...LoggerOption) *logger {
+	var o = defaultLoggerOptions()
+	for _, fn := range opts {
+		fn(o)
+	}
+	return &logger{h: h, opts: o, seen: map[logKey]*logSeen{}}
+}
+
+func (l *logger) log(level LoggerLevel, timestamp uint64, msg string) {
+	var ctx = context.Background()
+	if level.Level() < l.opts.level || !l.h.Enabled(ctx, level.Level()) {
+		return
+	}
+
+	t := FiletimeToTime(timestamp)
+	repeated, ok := l.limit(level, t, msg)
+	if !ok {
+		return
+	}
+
+	r := slog.NewRecord(t, level.Level(), msg, 0)
+	if l.opts.parse != nil {
+		if name, ok := l.opts.parse(msg); ok {
+			r.AddAttrs(slog.String("adapter", name))
+		}
+	}
+	if repeated > 0 {
+		r.AddAttrs(slog.Int("repeated", repeated))
+	}
+	l.h.Handle(ctx, r)
+}
+
+// limit the same message only log once in the interval, return the
+// suppressed times of the message since last logged
+func (l *logger) limit(level LoggerLevel, t time.Time, msg string) (repeated int, ok bool) {
+	if l.opts.interval <= 0 {
+		return 0, true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var key = logKey{level: level, msg: msg}
+	if s, has := l.seen[key]; has {
+		if t.Sub(s.last) < l.opts.interval {
+			s.suppressed++
+			return 0, false
+		}
+		repeated = s.suppressed
+		s.last, s.suppressed = t, 0
+		return repeated, true
+	}
+
+	if len(l.seen) >= maxLogSeen {
+		for k, s := range l.seen {
+			if t.Sub(s.last) >= l.opts.interval {
+				delete(l.seen, k)
+			}
 		}
 	}
+	if len(l.seen) < maxLogSeen {
+		l.seen[key] = &logSeen{last: t}
+	}
+	return 0, true
+}
+
+const maxLogSeen = 256
+
+// FiletimeToTime convert wintun timestamp (FILETIME, 100ns since 1601-01-01 UTC)
+// to time.Time
+func FiletimeToTime(ft uint64) time.Time {
+	// 100ns intervals between 1601-01-01 and 1970-01-01
+	const epoch = 116444736000000000
+	return time.Unix(0, (int64(ft)-epoch)*100)
+}
+
+var adapterNameExp = regexp.MustCompile(`(?i)adapter\s+"([^"]+)"|"([^"]+)"\s+adapter`)
+
+// ParseAdapterName parse adapter name from wintun message, such as:
+//
+//	Removing orphaned adapter "name"
+func ParseAdapterName(msg string) (name string, ok bool) {
+	m := adapterNameExp.FindStringSubmatch(msg)
+	if m == nil {
+		return "", false
+	} else if m[1] != "" {
+		return m[1], true
+	}
+	return m[2], true
+}
+
+// loggerCallback the logger used by the trampoline registered to wintun, the
+// trampoline is created only once, because windows callback can't be freed
+var loggerCallback atomic.Pointer[LoggerCallback]
+
+func storeLogger(logger LoggerCallback) {
+	if logger == nil {
+		loggerCallback.Store(nil)
+	} else {
+		loggerCallback.Store(&logger)
+	}
+}
+
+// dispatchLog called by trampoline, dispatch message to current logger
+func dispatchLog(level LoggerLevel, timestamp uint64, msg *uint16) uintptr {
+	if fn := loggerCallback.Load(); fn != nil {
+		return (*fn)(level, timestamp, msg)
+	}
+	return 0
+}
 
-	_, _, err := syscall.SyscallN(procSetLogger.Addr(), callback)
-	if err != windows.ERROR_SUCCESS {
-		return errors.WithStack(err)
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	var n int
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; n++ {
+		ptr = unsafe.Add(ptr, unsafe.Sizeof(*p))
 	}
-	return nil
+	return string(utf16.Decode(unsafe.Slice(p, n)))
 }
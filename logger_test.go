@@ -0,0 +1,122 @@
+package wintun
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+func utf16Msg(msg string) *uint16 {
+	return &utf16.Encode([]rune(msg + "\x00"))[0]
+}
+
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100 + 116444736000000000)
+}
+
+func Test_FiletimeToTime(t *testing.T) {
+	require.True(t, time.Unix(0, 0).Equal(FiletimeToTime(116444736000000000)))
+
+	now := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+	require.True(t, now.Equal(FiletimeToTime(filetime(now))))
+}
+
+func Test_ParseAdapterName(t *testing.T) {
+	name, ok := ParseAdapterName(`Removing orphaned adapter "testadapter"`)
+	require.True(t, ok)
+	require.Equal(t, "testadapter", name)
+
+	_, ok = ParseAdapterName("Creating adapter")
+	require.False(t, ok)
+}
+
+func Test_Dispatch_Logger(t *testing.T) {
+	defer storeLogger(nil)
+
+	t.Run("nil", func(t *testing.T) {
+		storeLogger(nil)
+		require.Zero(t, dispatchLog(Info, 0, utf16Msg("msg")))
+	})
+
+	t.Run("swap", func(t *testing.T) {
+		var a, b int
+		storeLogger(func(LoggerLevel, uint64, *uint16) uintptr { a++; return 0 })
+		dispatchLog(Info, 0, utf16Msg("msg"))
+		storeLogger(func(LoggerLevel, uint64, *uint16) uintptr { b++; return 0 })
+		dispatchLog(Info, 0, utf16Msg("msg"))
+
+		require.Equal(t, 1, a)
+		require.Equal(t, 1, b)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		storeLogger(nil)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				storeLogger(func(LoggerLevel, uint64, *uint16) uintptr { return 0 })
+			}()
+			go func() {
+				defer wg.Done()
+				dispatchLog(Warn, 0, utf16Msg("msg"))
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("record", func(t *testing.T) {
+		buff := bytes.NewBuffer(nil)
+		storeLogger(DefaultCallback(slog.New(slog.NewTextHandler(buff, nil))))
+
+		now := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+		dispatchLog(Warn, filetime(now), utf16Msg(`Removing orphaned adapter "test"`))
+
+		require.Contains(t, buff.String(), "time=2024-06-01T12:30:00.000Z")
+		require.Contains(t, buff.String(), "level=WARN")
+		require.Contains(t, buff.String(), "adapter=test")
+	})
+}
+
+func Test_Logger_Options(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+
+	t.Run("min-level", func(t *testing.T) {
+		buff := bytes.NewBuffer(nil)
+		l := newLogger(slog.NewTextHandler(buff, nil), MinLevel(slog.LevelWarn))
+
+		l.log(Info, filetime(now), "info")
+		l.log(Error, filetime(now), "error")
+		require.NotContains(t, buff.String(), "msg=info")
+		require.Contains(t, buff.String(), "msg=error")
+	})
+
+	t.Run("rate-limit", func(t *testing.T) {
+		buff := bytes.NewBuffer(nil)
+		l := newLogger(slog.NewTextHandler(buff, nil), RateLimit(time.Second))
+
+		for i := 0; i < 5; i++ {
+			l.log(Info, filetime(now.Add(time.Millisecond*time.Duration(i))), "repeat")
+		}
+		require.Equal(t, 1, bytes.Count(buff.Bytes(), []byte("msg=repeat")))
+
+		l.log(Info, filetime(now.Add(time.Second*2)), "repeat")
+		require.Equal(t, 2, bytes.Count(buff.Bytes(), []byte("msg=repeat")))
+		require.Contains(t, buff.String(), "repeated=4")
+	})
+
+	t.Run("not-parse", func(t *testing.T) {
+		buff := bytes.NewBuffer(nil)
+		l := newLogger(slog.NewTextHandler(buff, nil), AdapterParser(nil))
+
+		l.log(Info, filetime(now), `Removing orphaned adapter "test"`)
+		require.NotContains(t, buff.String(), "adapter=test")
+	})
+}
@@ -0,0 +1,59 @@
+package wintun
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MTUChange MTU of adapter changed not by SetMTU
+type MTUChange struct {
+	IPv6     bool
+	Old, New int
+}
+
+// validateMTU MTU must between family minimum and MaxMTU, zero means not
+// change the family
+func validateMTU(v4, v6 int) error {
+	if v4 != 0 && (v4 < MinMTU4 || v4 > MaxMTU) {
+		return errors.WithStack(invalidParameter(ErrInvalidMTU{MTU: v4}))
+	}
+	if v6 != 0 && (v6 < MinMTU6 || v6 > MaxMTU) {
+		return errors.WithStack(invalidParameter(ErrInvalidMTU{IPv6: true, MTU: v6}))
+	}
+	return nil
+}
+
+// mtuState the known MTU of adapter, used to distinguish external changes
+// from SetMTU, zero means unknown
+type mtuState struct {
+	mu     sync.Mutex
+	v4, v6 int
+}
+
+func (s *mtuState) family(v6 bool) *int {
+	if v6 {
+		return &s.v6
+	}
+	return &s.v4
+}
+
+// set record MTU set by SetMTU, return the previous
+func (s *mtuState) set(v6 bool, mtu int) (prev int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.family(v6)
+	prev, *p = *p, mtu
+	return prev
+}
+
+// observe record observed MTU, changed is true if it differs from the known,
+// the first observation is not a change
+func (s *mtuState) observe(v6 bool, mtu int) (c MTUChange, changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.family(v6)
+	c = MTUChange{IPv6: v6, Old: *p, New: mtu}
+	*p = mtu
+	return c, c.Old != 0 && c.Old != c.New
+}
@@ -0,0 +1,54 @@
+package wintun
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateMTU(t *testing.T) {
+	require.NoError(t, validateMTU(0, 0))
+	require.NoError(t, validateMTU(MinMTU4, MinMTU6))
+	require.NoError(t, validateMTU(1500, 0))
+	require.NoError(t, validateMTU(0, MaxMTU))
+
+	for _, c := range [][2]int{
+		{MinMTU4 - 1, 0}, {MaxMTU + 1, 0}, {-1, 0},
+		{0, MinMTU6 - 1}, {0, 576}, {1500, MaxMTU + 1},
+	} {
+		err := validateMTU(c[0], c[1])
+		var e ErrInvalidMTU
+		require.True(t, errors.As(err, &e), c)
+		if e.IPv6 {
+			require.Equal(t, c[1], e.MTU)
+		} else {
+			require.Equal(t, c[0], e.MTU)
+		}
+	}
+}
+
+func Test_MTUState(t *testing.T) {
+	var s mtuState
+
+	// first observation
+	_, changed := s.observe(false, 1500)
+	require.False(t, changed)
+	_, changed = s.observe(false, 1500)
+	require.False(t, changed)
+
+	c, changed := s.observe(false, 1400)
+	require.True(t, changed)
+	require.Equal(t, MTUChange{Old: 1500, New: 1400}, c)
+
+	// changed by SetMTU
+	require.Equal(t, 1400, s.set(false, 1420))
+	_, changed = s.observe(false, 1420)
+	require.False(t, changed)
+
+	// families are independent
+	require.Equal(t, 0, s.set(true, 1280))
+	c, changed = s.observe(true, 1500)
+	require.True(t, changed)
+	require.Equal(t, MTUChange{IPv6: true, Old: 1280, New: 1500}, c)
+}
@@ -0,0 +1,152 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+type mtuNotifier struct {
+	state mtuState
+	cb    *winipcfg.InterfaceChangeCallback
+
+	once sync.Once
+	err  error
+}
+
+// unregister stop notification, wait in-flight callbacks finished, it can be
+// called by both cancel and adapter Close
+func (n *mtuNotifier) unregister() error {
+	n.once.Do(func() { n.err = errors.WithStack(n.cb.Unregister()) })
+	return n.err
+}
+
+// MTU get IPv4 and IPv6 MTU of adapter IP interfaces, zero if the family
+// not enabled on adapter
+func (a *Adapter) MTU() (v4, v6 int, err error) {
+	luid, err := a.GetAdapterLuid()
+	if err != nil {
+		return 0, 0, err
+	}
+	if v4, err = familyMTU(luid, windows.AF_INET); err != nil {
+		return 0, 0, err
+	}
+	if v6, err = familyMTU(luid, windows.AF_INET6); err != nil {
+		return 0, 0, err
+	}
+	return v4, v6, nil
+}
+
+// SetMTU set IPv4 and IPv6 MTU of adapter IP interfaces, zero means not
+// change the family, the MTU not less than MinMTU4 and MinMTU6
+func (a *Adapter) SetMTU(v4, v6 int) error {
+	if err := validateMTU(v4, v6); err != nil {
+		return err
+	}
+	luid, err := a.GetAdapterLuid()
+	if err != nil {
+		return err
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for _, e := range []struct {
+		family winipcfg.AddressFamily
+		mtu    int
+	}{{windows.AF_INET, v4}, {windows.AF_INET6, v6}} {
+		if e.mtu == 0 {
+			continue
+		}
+		row, err := luid.IPInterface(e.family)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		// record before set, avoid notify the change of SetMTU
+		v6 := e.family == windows.AF_INET6
+		prevs := make([]int, len(a.notifiers))
+		for i, n := range a.notifiers {
+			prevs[i] = n.state.set(v6, e.mtu)
+		}
+
+		if e.family == windows.AF_INET {
+			row.SitePrefixLength = 0 // must be zero for IPv4
+		}
+		row.NLMTU = uint32(e.mtu)
+		if err := row.Set(); err != nil {
+			for i, n := range a.notifiers {
+				n.state.set(v6, prevs[i])
+			}
+			return errors.WithStack(err)
+		}
+	}
+	return nil
+}
+
+// NotifyMTU call fn when MTU of adapter changed not by SetMTU, every change is
+// dispatched to fn on its own goroutine, so fn may be called concurrently, and
+// it should not call cancel. the notification stop by cancel or adapter Close,
+// they wait the in-flight fn returned.
+func (a *Adapter) NotifyMTU(fn func(MTUChange)) (cancel func() error, err error) {
+	luid, err := a.GetAdapterLuid()
+	if err != nil {
+		return nil, err
+	}
+
+	var n = &mtuNotifier{}
+	n.cb, err = winipcfg.RegisterInterfaceChangeCallback(func(typ winipcfg.MibNotificationType, iface *winipcfg.MibIPInterfaceRow) {
+		if iface.InterfaceLUID != luid || typ != winipcfg.MibParameterNotification {
+			return
+		}
+		mtu, err := familyMTU(luid, iface.Family)
+		if err != nil || mtu == 0 {
+			return
+		}
+		if c, changed := n.state.observe(iface.Family == windows.AF_INET6, mtu); changed {
+			fn(c)
+		}
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	a.mu.Lock()
+	if a.handle == 0 || a.closed.Load() {
+		a.mu.Unlock()
+		if err := n.unregister(); err != nil {
+			return nil, err
+		}
+		return nil, errors.WithStack(ErrAdapterClosed{})
+	}
+	a.notifiers = append(a.notifiers, n)
+	a.mu.Unlock()
+
+	// the MTU before notification as initial
+	if v4, v6, err := a.MTU(); err == nil {
+		n.state.observe(false, v4)
+		n.state.observe(true, v6)
+	}
+
+	return func() error {
+		a.mu.Lock()
+		a.notifiers = slices.DeleteFunc(a.notifiers, func(e *mtuNotifier) bool { return e == n })
+		a.mu.Unlock()
+		return n.unregister()
+	}, nil
+}
+
+func familyMTU(luid winipcfg.LUID, family winipcfg.AddressFamily) (int, error) {
+	row, err := luid.IPInterface(family)
+	if err == windows.ERROR_NOT_FOUND {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return int(row.NLMTU), nil
+}
@@ -1,35 +1,44 @@
 package wintun
 
-import "golang.org/x/sys/windows"
+import (
+	"log/slog"
+	"time"
+)
 
-type options struct {
-	tunType  string
-	guid     *windows.GUID
-	ringBuff uint32
+type loggerOptions struct {
+	level    slog.Level
+	interval time.Duration
+	parse    func(msg string) (name string, ok bool)
 }
 
-func defaultOptions() *options {
-	return &options{
-		ringBuff: MinRingCapacity,
+func defaultLoggerOptions() *loggerOptions {
+	return &loggerOptions{
+		level: slog.LevelDebug,
+		parse: ParseAdapterName,
 	}
 }
 
-type Option func(*options)
+type LoggerOption func(*loggerOptions)
 
-func TunType(typ string) Option {
-	return func(o *options) {
-		o.tunType = typ
+// MinLevel only log the message that level not less than level
+func MinLevel(level slog.Level) LoggerOption {
+	return func(o *loggerOptions) {
+		o.level = level
 	}
 }
 
-func Guid(guid *windows.GUID) Option {
-	return func(o *options) {
-		o.guid = guid
+// RateLimit the same message only log once in interval, the suppressed times
+// will be logged with attribute "repeated", default not limit
+func RateLimit(interval time.Duration) LoggerOption {
+	return func(o *loggerOptions) {
+		o.interval = interval
 	}
 }
 
-func RingBuff(size uint32) Option {
-	return func(o *options) {
-		o.ringBuff = size
+// AdapterParser parse adapter name from message as attribute "adapter",
+// default ParseAdapterName, nil means not parse
+func AdapterParser(fn func(msg string) (name string, ok bool)) LoggerOption {
+	return func(o *loggerOptions) {
+		o.parse = fn
 	}
 }
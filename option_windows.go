@@ -0,0 +1,80 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+type options struct {
+	tunType  string
+	guid     *windows.GUID
+	ringBuff uint32
+
+	namespace *GUID
+	adaptive  *AdaptiveConfig
+	start     bool
+}
+
+func defaultOptions() *options {
+	return &options{
+		tunType:  DefaultTunType,
+		ringBuff: MinRingCapacity,
+		start:    true,
+	}
+}
+
+type Option func(*options)
+
+func TunType(typ string) Option {
+	return func(o *options) {
+		o.tunType = typ
+	}
+}
+
+func Guid(guid *windows.GUID) Option {
+	return func(o *options) {
+		o.guid = guid
+	}
+}
+
+// DerivedGuid use the GUID derived from namespace and adapter name, see DeriveGuid,
+// it's ignored if Guid option is set
+func DerivedGuid(namespace GUID) Option {
+	return func(o *options) {
+		o.namespace = &namespace
+	}
+}
+
+func RingBuff(size uint32) Option {
+	return func(o *options) {
+		o.ringBuff = size
+	}
+}
+
+// AutoRingBuff set ring capacity computed by RingCapacity
+func AutoRingBuff(bandwidth uint64, burst time.Duration, mtu int) Option {
+	return func(o *options) {
+		o.ringBuff = RingCapacity(bandwidth, burst, mtu)
+	}
+}
+
+// AdaptiveRing restart session with larger ring capacity when sustained pressure
+// detected, see AdaptiveConfig
+func AdaptiveRing(cfg AdaptiveConfig) Option {
+	return func(o *options) {
+		o.adaptive = &cfg
+	}
+}
+
+// AutoStart whether start session with RingBuff capacity after adapter created
+// or opened, default true. if not, should call Adapter.Start before Recv/Send,
+// it's useful when only query adapter information
+func AutoStart(start bool) Option {
+	return func(o *options) {
+		o.start = start
+	}
+}
@@ -0,0 +1,85 @@
+package wintun
+
+import (
+	"context"
+	"math/bits"
+	"sync"
+)
+
+// MaxPacketSize maximum ip packet size of wintun
+const MaxPacketSize = 0xffff
+
+const (
+	minClassShift = 7  // 128B
+	maxClassShift = 16 // 64KiB
+)
+
+// pools size-classed buffer pools, the buffer size of class i is
+// 1<<(minClassShift+i)
+var pools [maxClassShift - minClassShift + 1]sync.Pool
+
+func init() {
+	for i := range pools {
+		size := 1 << (minClassShift + i)
+		pools[i].New = func() any {
+			b := make([]byte, size)
+			return &b
+		}
+	}
+}
+
+// sizeClass the smallest class can hold size bytes
+func sizeClass(size int) int {
+	if size <= 1<<minClassShift {
+		return 0
+	}
+	return bits.Len(uint(size-1)) - minClassShift
+}
+
+// Packet ip packet hold buffer from pool, must call Release after used
+type Packet struct {
+	buf *[]byte
+	n   int
+}
+
+// NewPacket get packet with size bytes from pool
+func NewPacket(size int) Packet {
+	if size > MaxPacketSize {
+		size = MaxPacketSize
+	} else if size < 0 {
+		size = 0
+	}
+	return Packet{buf: pools[sizeClass(size)].Get().(*[]byte), n: size}
+}
+
+// Bytes ip packet bytes, invalid after Release
+func (p Packet) Bytes() []byte {
+	if p.buf == nil {
+		return nil
+	}
+	return (*p.buf)[:p.n]
+}
+
+// Release return buffer to pool, the packet and it's copies can't be used
+// after release
+func (p Packet) Release() {
+	if p.buf != nil {
+		pools[sizeClass(cap(*p.buf))].Put(p.buf)
+	}
+}
+
+// ReadPacket receive packet from dev, and copy it to pooled Packet
+func ReadPacket(ctx context.Context, dev Device) (Packet, error) {
+	ip, err := dev.Recv(ctx)
+	if err != nil {
+		return Packet{}, err
+	}
+	pkt := NewPacket(len(ip))
+	copy(pkt.Bytes(), ip)
+
+	if err := dev.Release(ip); err != nil {
+		pkt.Release()
+		return Packet{}, err
+	}
+	return pkt, nil
+}
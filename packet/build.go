@@ -0,0 +1,315 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// ICMP echo types
+const (
+	ICMPEchoReply     uint8 = 0
+	ICMPEchoRequest   uint8 = 8
+	ICMPv6EchoRequest uint8 = 128
+	ICMPv6EchoReply   uint8 = 129
+)
+
+// ICMP destination unreachable type and codes
+const (
+	ICMPDestUnreachable uint8 = 3
+
+	ICMPNetUnreachable  uint8 = 0
+	ICMPHostUnreachable uint8 = 1
+	ICMPPortUnreachable uint8 = 3
+	ICMPAdminProhibited uint8 = 13
+)
+
+// ICMPv6 destination unreachable type and codes
+const (
+	ICMPv6DestUnreachable uint8 = 1
+
+	ICMPv6NoRoute            uint8 = 0
+	ICMPv6AdminProhibited    uint8 = 1
+	ICMPv6AddressUnreachable uint8 = 3
+	ICMPv6PortUnreachable    uint8 = 4
+)
+
+// maximum ICMPv6 error message size, not exceed IPv6 minimum MTU
+const maxICMPv6Error = 1280 - IPv6Size
+
+// maximum TCP options length
+const maxTCPOptions = 40
+
+type ErrShortBuffer struct{}
+
+func (ErrShortBuffer) Error() string { return "short buffer" }
+
+type ErrInvalidAddr struct{}
+
+func (ErrInvalidAddr) Error() string { return "invalid source or destination address" }
+
+// IP ip header fields used to build packet, the version is decided by
+// address family
+type IP struct {
+	Src, Dst netip.Addr
+
+	// TTL ttl or hop limit, default 64
+	TTL uint8
+	// TOS tos or traffic class
+	TOS uint8
+	// ID IPv4 identification
+	ID uint16
+}
+
+func (h *IP) valid() bool {
+	return h.Src.IsValid() && h.Dst.IsValid() &&
+		h.Src.Unmap().Is4() == h.Dst.Unmap().Is4()
+}
+
+func (h *IP) is4() bool { return h.Src.Unmap().Is4() }
+
+func (h *IP) size() int {
+	if h.is4() {
+		return IPv4MinSize
+	}
+	return IPv6Size
+}
+
+// Transport transport layer of built packet
+type Transport interface {
+	// proto ip protocol number of transport
+	proto(v4 bool) uint8
+	size() int
+	// encode encode transport into b, except checksum
+	encode(b []byte, v4 bool)
+	// checksum offset of checksum field, pseudo the checksum include
+	// pseudo header
+	checksum(v4 bool) (off int, pseudo bool)
+}
+
+// Echo ICMP or ICMPv6 echo message, the type is decided by address family
+type Echo struct {
+	Reply   bool
+	ID, Seq uint16
+	Data    []byte
+}
+
+func (e *Echo) proto(v4 bool) uint8 {
+	if v4 {
+		return ICMP
+	}
+	return ICMPv6
+}
+
+func (e *Echo) size() int { return ICMPMinSize + len(e.Data) }
+
+func (e *Echo) encode(b []byte, v4 bool) {
+	switch {
+	case v4 && e.Reply:
+		b[0] = ICMPEchoReply
+	case v4:
+		b[0] = ICMPEchoRequest
+	case e.Reply:
+		b[0] = ICMPv6EchoReply
+	default:
+		b[0] = ICMPv6EchoRequest
+	}
+	b[1] = 0
+	binary.BigEndian.PutUint16(b[4:], e.ID)
+	binary.BigEndian.PutUint16(b[6:], e.Seq)
+	copy(b[ICMPMinSize:], e.Data)
+}
+
+func (e *Echo) checksum(v4 bool) (int, bool) { return 2, !v4 }
+
+// Unreachable ICMP or ICMPv6 destination unreachable message, the type is
+// decided by address family, Code must be the code of the family. Data is
+// the invoking packet, truncated to ensure ICMPv6 message not exceed minimum
+// MTU, for ICMP, the ip header and leading 8 bytes payload is enough.
+type Unreachable struct {
+	Code uint8
+	Data []byte
+}
+
+func (u *Unreachable) proto(v4 bool) uint8 {
+	if v4 {
+		return ICMP
+	}
+	return ICMPv6
+}
+
+func (u *Unreachable) size() int {
+	return ICMPMinSize + min(len(u.Data), maxICMPv6Error-ICMPMinSize)
+}
+
+func (u *Unreachable) encode(b []byte, v4 bool) {
+	if v4 {
+		b[0] = ICMPDestUnreachable
+	} else {
+		b[0] = ICMPv6DestUnreachable
+	}
+	b[1] = u.Code
+	clear(b[4:ICMPMinSize])
+	copy(b[ICMPMinSize:], u.Data)
+}
+
+func (u *Unreachable) checksum(v4 bool) (int, bool) { return 2, !v4 }
+
+// UDPSegment UDP datagram
+type UDPSegment struct {
+	SrcPort, DstPort uint16
+	Payload          []byte
+}
+
+func (u *UDPSegment) proto(bool) uint8 { return UDP }
+
+func (u *UDPSegment) size() int { return UDPSize + len(u.Payload) }
+
+func (u *UDPSegment) encode(b []byte, _ bool) {
+	binary.BigEndian.PutUint16(b[0:], u.SrcPort)
+	binary.BigEndian.PutUint16(b[2:], u.DstPort)
+	binary.BigEndian.PutUint16(b[4:], uint16(u.size()))
+	copy(b[UDPSize:], u.Payload)
+}
+
+func (u *UDPSegment) checksum(bool) (int, bool) { return 6, true }
+
+// TCPSegment TCP segment, Options is raw options, padded to 4 bytes with
+// End of Option List
+type TCPSegment struct {
+	SrcPort, DstPort uint16
+	Seq, Ack         uint32
+	Flags            uint8
+	Window           uint16
+	Urgent           uint16
+	Options          []byte
+	Payload          []byte
+}
+
+func (t *TCPSegment) proto(bool) uint8 { return TCP }
+
+func (t *TCPSegment) hdrLen() int { return TCPMinSize + (len(t.Options)+3)&^3 }
+
+func (t *TCPSegment) size() int { return t.hdrLen() + len(t.Payload) }
+
+func (t *TCPSegment) encode(b []byte, _ bool) {
+	hdrLen := t.hdrLen()
+	binary.BigEndian.PutUint16(b[0:], t.SrcPort)
+	binary.BigEndian.PutUint16(b[2:], t.DstPort)
+	binary.BigEndian.PutUint32(b[4:], t.Seq)
+	binary.BigEndian.PutUint32(b[8:], t.Ack)
+	b[12] = uint8(hdrLen/4) << 4
+	b[13] = t.Flags
+	binary.BigEndian.PutUint16(b[14:], t.Window)
+	binary.BigEndian.PutUint16(b[18:], t.Urgent)
+	n := copy(b[TCPMinSize:], t.Options)
+	clear(b[TCPMinSize+n : hdrLen])
+	copy(b[hdrLen:], t.Payload)
+}
+
+func (t *TCPSegment) checksum(bool) (int, bool) { return 16, true }
+
+// Size validate fields and get total length of packet built by Build
+func Size(h IP, t Transport) (int, error) {
+	if !h.valid() {
+		return 0, ErrInvalidAddr{}
+	}
+	if tcp, ok := t.(*TCPSegment); ok && len(tcp.Options) > maxTCPOptions {
+		return 0, ErrInvalidHeader{}
+	}
+	n := h.size() + t.size()
+	if n > 0xffff+h.size() || (h.is4() && n > 0xffff) {
+		return 0, ErrInvalidHeader{}
+	}
+	return n, nil
+}
+
+// Build build ip packet into b, compute IP and transport checksums, return
+// the packet length. b usually is allocated by Device.Alloc with Size(h, t),
+// reference wintun.Inject
+func Build(b []byte, h IP, t Transport) (int, error) {
+	n, err := Size(h, t)
+	if err != nil {
+		return 0, err
+	} else if len(b) < n {
+		return 0, ErrShortBuffer{}
+	}
+	b = b[:n]
+	v4, hdrLen := h.is4(), h.size()
+
+	ttl := h.TTL
+	if ttl == 0 {
+		ttl = 64
+	}
+	proto := t.proto(v4)
+	if v4 {
+		b[0] = 0x45
+		b[1] = h.TOS
+		binary.BigEndian.PutUint16(b[2:], uint16(n))
+		binary.BigEndian.PutUint16(b[4:], h.ID)
+		binary.BigEndian.PutUint16(b[6:], 0)
+		b[8] = ttl
+		b[9] = proto
+		binary.BigEndian.PutUint16(b[10:], 0)
+		src, dst := h.Src.Unmap().As4(), h.Dst.Unmap().As4()
+		copy(b[12:], src[:])
+		copy(b[16:], dst[:])
+		binary.BigEndian.PutUint16(b[10:], ^Checksum(b[:hdrLen], 0))
+	} else {
+		binary.BigEndian.PutUint32(b[0:], 6<<28|uint32(h.TOS)<<20)
+		binary.BigEndian.PutUint16(b[4:], uint16(n-hdrLen))
+		b[6] = proto
+		b[7] = ttl
+		src, dst := h.Src.As16(), h.Dst.As16()
+		copy(b[8:], src[:])
+		copy(b[24:], dst[:])
+	}
+
+	tb := b[hdrLen:]
+	t.encode(tb, v4)
+	off, pseudo := t.checksum(v4)
+	binary.BigEndian.PutUint16(tb[off:], 0)
+	var sum uint32
+	if pseudo {
+		sum = PseudoSum(h.Src, h.Dst, proto, len(tb))
+	}
+	cs := ^Checksum(tb, sum)
+	if cs == 0 && proto == UDP {
+		cs = 0xffff
+	}
+	binary.BigEndian.PutUint16(tb[off:], cs)
+	return n, nil
+}
+
+// Checksum ones' complement sum of b, initial is the partial sum, such as
+// PseudoSum, the result need complement before write to header
+func Checksum(b []byte, initial uint32) uint16 {
+	sum := uint64(initial)
+	for len(b) >= 8 {
+		sum += uint64(binary.BigEndian.Uint32(b)) + uint64(binary.BigEndian.Uint32(b[4:]))
+		b = b[8:]
+	}
+	for len(b) >= 2 {
+		sum += uint64(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint64(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
+
+// PseudoSum partial sum of transport pseudo header
+func PseudoSum(src, dst netip.Addr, proto uint8, length int) uint32 {
+	var sum uint32
+	if src.Unmap().Is4() {
+		s, d := src.Unmap().As4(), dst.Unmap().As4()
+		sum = uint32(Checksum(s[:], 0)) + uint32(Checksum(d[:], 0))
+	} else {
+		s, d := src.As16(), dst.As16()
+		sum = uint32(Checksum(s[:], 0)) + uint32(Checksum(d[:], 0))
+	}
+	return sum + uint32(proto) + uint32(length>>16) + uint32(length&0xffff)
+}
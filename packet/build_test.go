@@ -0,0 +1,207 @@
+package packet_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func build(t *testing.T, h packet.IP, tr packet.Transport) []byte {
+	n, err := packet.Size(h, tr)
+	require.NoError(t, err)
+
+	b := make([]byte, n)
+	m, err := packet.Build(b, h, tr)
+	require.NoError(t, err)
+	require.Equal(t, n, m)
+	return b
+}
+
+// transportValid validate transport checksum by gvisor
+func transportValid(t *testing.T, b []byte) {
+	info, err := packet.Parse(b)
+	require.NoError(t, err)
+
+	var (
+		tr  = info.Transport(b)
+		src = tcpip.AddrFromSlice(info.Src.AsSlice())
+		dst = tcpip.AddrFromSlice(info.Dst.AsSlice())
+		sum uint16
+	)
+	switch info.Proto {
+	case packet.TCP, packet.UDP, packet.ICMPv6:
+		sum = header.PseudoHeaderChecksum(tcpip.TransportProtocolNumber(info.Proto), src, dst, uint16(len(tr)))
+	}
+	require.Equal(t, uint16(0xffff), checksum.Checksum(tr, sum))
+}
+
+func Test_Build_IPv4(t *testing.T) {
+	h := packet.IP{Src: src4, Dst: dst4, ID: 7}
+
+	t.Run("echo", func(t *testing.T) {
+		b := build(t, h, &packet.Echo{ID: 1, Seq: 2, Data: []byte("1234")})
+
+		iphdr := header.IPv4(b)
+		require.True(t, iphdr.IsValid(len(b)))
+		require.True(t, iphdr.IsChecksumValid())
+		require.Equal(t, uint8(64), iphdr.TTL())
+		require.Equal(t, uint16(7), iphdr.ID())
+
+		icmp := header.ICMPv4(iphdr.Payload())
+		require.Equal(t, header.ICMPv4Echo, icmp.Type())
+		require.Equal(t, uint16(1), icmp.Ident())
+		require.Equal(t, uint16(2), icmp.Sequence())
+		require.Equal(t, "1234", string(icmp.Payload()))
+		transportValid(t, b)
+
+		b = build(t, h, &packet.Echo{Reply: true})
+		require.Equal(t, header.ICMPv4EchoReply, header.ICMPv4(header.IPv4(b).Payload()).Type())
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		b := build(t, h, &packet.UDPSegment{SrcPort: 5353, DstPort: 53, Payload: []byte("odd")})
+
+		require.True(t, header.IPv4(b).IsChecksumValid())
+		udp := header.UDP(header.IPv4(b).Payload())
+		require.Equal(t, uint16(5353), udp.SourcePort())
+		require.Equal(t, uint16(53), udp.DestinationPort())
+		require.Equal(t, uint16(len(udp)), udp.Length())
+		transportValid(t, b)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		b := build(t, h, &packet.TCPSegment{
+			SrcPort: 1234, DstPort: 80,
+			Seq: 100, Ack: 200,
+			Flags:   packet.SYN | packet.ACK,
+			Window:  0xffff,
+			Options: []byte{2, 4, 0x05, 0xb4, 1, 1}, // MSS 1460, NOP, NOP
+			Payload: []byte("hello"),
+		})
+
+		tcp := header.TCP(header.IPv4(b).Payload())
+		require.Equal(t, uint8(28), tcp.DataOffset())
+		require.Equal(t, uint32(100), tcp.SequenceNumber())
+		require.Equal(t, uint32(200), tcp.AckNumber())
+		require.Equal(t, header.TCPFlagSyn|header.TCPFlagAck, tcp.Flags())
+		require.Equal(t, []byte{2, 4, 0x05, 0xb4, 1, 1, 0, 0}, tcp.Options())
+		require.Equal(t, "hello", string(tcp.Payload()))
+		transportValid(t, b)
+	})
+}
+
+func Test_Build_IPv6(t *testing.T) {
+	h := packet.IP{Src: src6, Dst: dst6, TTL: 255, TOS: 0xb8}
+
+	t.Run("echo", func(t *testing.T) {
+		b := build(t, h, &packet.Echo{ID: 1, Seq: 2, Data: []byte("ping")})
+
+		iphdr := header.IPv6(b)
+		require.True(t, iphdr.IsValid(len(b)))
+		require.Equal(t, uint8(255), iphdr.HopLimit())
+		tc, _ := iphdr.TOS()
+		require.Equal(t, uint8(0xb8), tc)
+
+		icmp := header.ICMPv6(iphdr.Payload())
+		require.Equal(t, header.ICMPv6EchoRequest, icmp.Type())
+		require.Equal(t, "ping", string(icmp.Payload()))
+		transportValid(t, b)
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		b := build(t, h, &packet.UDPSegment{SrcPort: 1, DstPort: 2, Payload: []byte("hello")})
+		transportValid(t, b)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		b := build(t, h, &packet.TCPSegment{SrcPort: 1, DstPort: 2, Flags: packet.RST})
+		transportValid(t, b)
+	})
+}
+
+func Test_Build_Invalid(t *testing.T) {
+	t.Run("family", func(t *testing.T) {
+		_, err := packet.Size(packet.IP{Src: src4, Dst: dst6}, &packet.Echo{})
+		require.ErrorIs(t, err, packet.ErrInvalidAddr{})
+
+		_, err = packet.Size(packet.IP{Src: src4}, &packet.Echo{})
+		require.ErrorIs(t, err, packet.ErrInvalidAddr{})
+	})
+
+	t.Run("mapped", func(t *testing.T) {
+		h := packet.IP{Src: netip.AddrFrom16(src4.As16()), Dst: dst4}
+		b := build(t, h, &packet.Echo{})
+		require.Equal(t, uint8(4), packet.Version(b))
+		require.True(t, header.IPv4(b).IsChecksumValid())
+	})
+
+	t.Run("short-buffer", func(t *testing.T) {
+		_, err := packet.Build(make([]byte, 27), packet.IP{Src: src4, Dst: dst4}, &packet.Echo{})
+		require.ErrorIs(t, err, packet.ErrShortBuffer{})
+	})
+
+	t.Run("too-large", func(t *testing.T) {
+		_, err := packet.Size(packet.IP{Src: src4, Dst: dst4}, &packet.UDPSegment{Payload: make([]byte, 0xffff)})
+		require.ErrorIs(t, err, packet.ErrInvalidHeader{})
+	})
+
+	t.Run("tcp-options", func(t *testing.T) {
+		_, err := packet.Size(packet.IP{Src: src4, Dst: dst4}, &packet.TCPSegment{Options: make([]byte, 41)})
+		require.ErrorIs(t, err, packet.ErrInvalidHeader{})
+	})
+}
+
+func Test_Checksum(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 7, 8, 9, 1500} {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i*7 + 3)
+		}
+		require.Equal(t, checksum.Checksum(b, 0x1234), packet.Checksum(b, 0x1234), n)
+	}
+}
+
+func Benchmark_Build(b *testing.B) {
+	var (
+		h   = packet.IP{Src: src4, Dst: dst4}
+		udp = &packet.UDPSegment{SrcPort: 1, DstPort: 2, Payload: make([]byte, 1400)}
+		p   = make([]byte, 1500)
+	)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := packet.Build(p, h, udp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_Build_Unreachable(t *testing.T) {
+	t.Run("ipv4", func(t *testing.T) {
+		orig := build(t, packet.IP{Src: dst4, Dst: src4}, &packet.UDPSegment{SrcPort: 1, DstPort: 2, Payload: []byte("data")})
+		b := build(t, packet.IP{Src: src4, Dst: dst4}, &packet.Unreachable{Code: packet.ICMPPortUnreachable, Data: orig})
+
+		icmp := header.ICMPv4(header.IPv4(b).Payload())
+		require.Equal(t, header.ICMPv4DstUnreachable, icmp.Type())
+		require.Equal(t, header.ICMPv4PortUnreachable, icmp.Code())
+		require.Equal(t, orig, []byte(icmp.Payload()))
+		transportValid(t, b)
+	})
+
+	t.Run("ipv6-truncate", func(t *testing.T) {
+		orig := build(t, packet.IP{Src: dst6, Dst: src6}, &packet.UDPSegment{Payload: make([]byte, 1400)})
+		b := build(t, packet.IP{Src: src6, Dst: dst6}, &packet.Unreachable{Code: packet.ICMPv6PortUnreachable, Data: orig})
+		require.Equal(t, 1280, len(b))
+
+		icmp := header.ICMPv6(header.IPv6(b).Payload())
+		require.Equal(t, header.ICMPv6DstUnreachable, icmp.Type())
+		require.Equal(t, header.ICMPv6PortUnreachable, icmp.Code())
+		require.Equal(t, orig[:1280-48], []byte(icmp.Payload()))
+		transportValid(t, b)
+	})
+}
@@ -0,0 +1,246 @@
+// Package packet allocation-free ip packet parsing and building helpers, for
+// the packets received from or sent to wintun adapter.
+package packet
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// ip protocol numbers
+const (
+	HopByHop uint8 = 0
+	ICMP     uint8 = 1
+	TCP      uint8 = 6
+	UDP      uint8 = 17
+	Routing  uint8 = 43
+	Fragment uint8 = 44
+	ESP      uint8 = 50
+	AH       uint8 = 51
+	ICMPv6   uint8 = 58
+	NoNext   uint8 = 59
+	DestOpts uint8 = 60
+	Mobility uint8 = 135
+	HIP      uint8 = 139
+	Shim6    uint8 = 140
+)
+
+// maximum IPv6 extension headers can be parsed
+const maxIPv6Ex = 8
+
+const (
+	IPv4MinSize = 20
+	IPv6Size    = 40
+	TCPMinSize  = 20
+	UDPSize     = 8
+	ICMPMinSize = 8
+)
+
+// TCP flags
+const (
+	FIN uint8 = 1 << iota
+	SYN
+	RST
+	PSH
+	ACK
+	URG
+	ECE
+	CWR
+)
+
+type ErrTruncated struct{}
+
+func (ErrTruncated) Error() string { return "packet truncated" }
+
+type ErrInvalidVersion struct{}
+
+func (ErrInvalidVersion) Error() string { return "invalid ip version" }
+
+type ErrInvalidHeader struct{}
+
+func (ErrInvalidHeader) Error() string { return "invalid header" }
+
+// Info parsed ip packet information
+type Info struct {
+	Version  uint8
+	Src, Dst netip.Addr
+	TTL      uint8
+
+	// Proto transport protocol, after IPv6 extension headers
+	Proto uint8
+	// Exts IPv6 extension header chain, valid length is NumExts
+	Exts    [maxIPv6Ex]uint8
+	NumExts int
+
+	// Fragmented packet is fragment, the non-first fragment not contain
+	// transport header
+	Fragmented bool
+	FragOffset uint16 // in bytes
+
+	// transport header
+	SrcPort, DstPort uint16
+	TCPFlags         uint8
+	ICMPType         uint8
+	ICMPCode         uint8
+
+	// TransportOffset offset of transport header, equal total ip header length
+	TransportOffset int
+	// PayloadOffset offset of transport payload, equal TransportOffset if
+	// transport not parsed
+	PayloadOffset int
+	// Len packet length indicated by ip header, the trailing bytes are ignored
+	Len int
+}
+
+// Transport transport header and payload
+func (i *Info) Transport(b []byte) []byte { return b[i.TransportOffset:i.Len] }
+
+// Payload transport payload
+func (i *Info) Payload(b []byte) []byte { return b[i.PayloadOffset:i.Len] }
+
+// Version ip version of packet, 0 if empty
+func Version(b []byte) uint8 {
+	if len(b) == 0 {
+		return 0
+	}
+	return b[0] >> 4
+}
+
+// Parse parse ip packet, the transport header is parsed for TCP, UDP, ICMP and
+// ICMPv6, it not allocate.
+func Parse(b []byte) (info Info, err error) {
+	switch Version(b) {
+	case 4:
+		err = info.parseIPv4(b)
+	case 6:
+		err = info.parseIPv6(b)
+	default:
+		return Info{}, ErrInvalidVersion{}
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	info.PayloadOffset = info.TransportOffset
+	if info.Fragmented && info.FragOffset > 0 {
+		return info, nil
+	}
+	if err = info.parseTransport(b[info.TransportOffset:info.Len]); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
+
+func (i *Info) parseIPv4(b []byte) error {
+	if len(b) < IPv4MinSize {
+		return ErrTruncated{}
+	}
+	hdrLen := int(b[0]&0xf) * 4
+	total := int(binary.BigEndian.Uint16(b[2:]))
+	if hdrLen < IPv4MinSize || total < hdrLen {
+		return ErrInvalidHeader{}
+	} else if total > len(b) {
+		return ErrTruncated{}
+	}
+
+	frag := binary.BigEndian.Uint16(b[6:])
+	i.Version = 4
+	i.TTL = b[8]
+	i.Proto = b[9]
+	i.Src = netip.AddrFrom4([4]byte(b[12:16]))
+	i.Dst = netip.AddrFrom4([4]byte(b[16:20]))
+	i.Fragmented = frag&0x3fff != 0
+	i.FragOffset = (frag & 0x1fff) * 8
+	i.TransportOffset = hdrLen
+	i.Len = total
+	return nil
+}
+
+func (i *Info) parseIPv6(b []byte) error {
+	if len(b) < IPv6Size {
+		return ErrTruncated{}
+	}
+	total := IPv6Size + int(binary.BigEndian.Uint16(b[4:]))
+	if total > len(b) {
+		return ErrTruncated{}
+	}
+
+	i.Version = 6
+	i.TTL = b[7]
+	i.Src = netip.AddrFrom16([16]byte(b[8:24]))
+	i.Dst = netip.AddrFrom16([16]byte(b[24:40]))
+	i.Len = total
+
+	next, off := b[6], IPv6Size
+	for {
+		var extLen int
+		switch next {
+		case HopByHop, Routing, DestOpts, Mobility, HIP, Shim6:
+			if off+8 > total {
+				return ErrTruncated{}
+			}
+			extLen = (int(b[off+1]) + 1) * 8
+		case AH:
+			if off+8 > total {
+				return ErrTruncated{}
+			}
+			extLen = (int(b[off+1]) + 2) * 4
+		case Fragment:
+			if off+8 > total {
+				return ErrTruncated{}
+			}
+			extLen = 8
+			frag := binary.BigEndian.Uint16(b[off+2:])
+			i.Fragmented = true
+			i.FragOffset = frag &^ 0x7
+		default:
+			// upper layer, ESP or NoNext
+			i.Proto = next
+			i.TransportOffset = off
+			return nil
+		}
+
+		if off+extLen > total {
+			return ErrTruncated{}
+		} else if i.NumExts >= len(i.Exts) {
+			return ErrInvalidHeader{}
+		}
+		i.Exts[i.NumExts] = next
+		i.NumExts++
+		next, off = b[off], off+extLen
+	}
+}
+
+func (i *Info) parseTransport(b []byte) error {
+	switch i.Proto {
+	case TCP:
+		if len(b) < TCPMinSize {
+			return ErrTruncated{}
+		}
+		hdrLen := int(b[12]>>4) * 4
+		if hdrLen < TCPMinSize {
+			return ErrInvalidHeader{}
+		} else if hdrLen > len(b) {
+			return ErrTruncated{}
+		}
+		i.SrcPort = binary.BigEndian.Uint16(b[0:])
+		i.DstPort = binary.BigEndian.Uint16(b[2:])
+		i.TCPFlags = b[13]
+		i.PayloadOffset += hdrLen
+	case UDP:
+		if len(b) < UDPSize {
+			return ErrTruncated{}
+		}
+		i.SrcPort = binary.BigEndian.Uint16(b[0:])
+		i.DstPort = binary.BigEndian.Uint16(b[2:])
+		i.PayloadOffset += UDPSize
+	case ICMP, ICMPv6:
+		if len(b) < ICMPMinSize {
+			return ErrTruncated{}
+		}
+		i.ICMPType = b[0]
+		i.ICMPCode = b[1]
+		i.PayloadOffset += ICMPMinSize
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package packet_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+var (
+	src4 = netip.MustParseAddr("10.0.0.1")
+	dst4 = netip.MustParseAddr("10.0.0.2")
+	src6 = netip.MustParseAddr("fd00::1")
+	dst6 = netip.MustParseAddr("fd00::2")
+)
+
+func ipv4(proto uint8, payload []byte) []byte {
+	var b = make([]byte, header.IPv4MinimumSize+len(payload))
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		TTL:         64,
+		Protocol:    proto,
+		SrcAddr:     tcpip.AddrFrom4(src4.As4()),
+		DstAddr:     tcpip.AddrFrom4(dst4.As4()),
+	})
+	copy(b[header.IPv4MinimumSize:], payload)
+	return b
+}
+
+func ipv6(proto uint8, exts []byte, payload []byte) []byte {
+	var b = make([]byte, header.IPv6MinimumSize+len(exts)+len(payload))
+	header.IPv6(b).Encode(&header.IPv6Fields{
+		PayloadLength:     uint16(len(exts) + len(payload)),
+		TransportProtocol: tcpip.TransportProtocolNumber(proto),
+		HopLimit:          64,
+		SrcAddr:           tcpip.AddrFrom16(src6.As16()),
+		DstAddr:           tcpip.AddrFrom16(dst6.As16()),
+	})
+	copy(b[header.IPv6MinimumSize:], exts)
+	copy(b[header.IPv6MinimumSize+len(exts):], payload)
+	return b
+}
+
+func tcp(payload []byte) []byte {
+	var b = make([]byte, header.TCPMinimumSize+len(payload))
+	header.TCP(b).Encode(&header.TCPFields{
+		SrcPort:    1234,
+		DstPort:    80,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      header.TCPFlagSyn | header.TCPFlagAck,
+	})
+	copy(b[header.TCPMinimumSize:], payload)
+	return b
+}
+
+func udp(payload []byte) []byte {
+	var b = make([]byte, header.UDPMinimumSize+len(payload))
+	header.UDP(b).Encode(&header.UDPFields{
+		SrcPort: 5353,
+		DstPort: 53,
+		Length:  uint16(len(b)),
+	})
+	copy(b[header.UDPMinimumSize:], payload)
+	return b
+}
+
+func icmp(typ uint8, payload []byte) []byte {
+	var b = make([]byte, 8+len(payload))
+	b[0] = typ
+	copy(b[8:], payload)
+	return b
+}
+
+func Test_Parse_IPv4(t *testing.T) {
+	t.Run("tcp", func(t *testing.T) {
+		b := ipv4(packet.TCP, tcp([]byte("hello")))
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, uint8(4), info.Version)
+		require.Equal(t, src4, info.Src)
+		require.Equal(t, dst4, info.Dst)
+		require.Equal(t, packet.TCP, info.Proto)
+		require.Equal(t, uint16(1234), info.SrcPort)
+		require.Equal(t, uint16(80), info.DstPort)
+		require.Equal(t, packet.SYN|packet.ACK, info.TCPFlags)
+		require.Equal(t, 20, info.TransportOffset)
+		require.Equal(t, "hello", string(info.Payload(b)))
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		b := ipv4(packet.UDP, udp([]byte("hello")))
+		b = append(b, 0, 0, 0) // trailing bytes
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, uint16(5353), info.SrcPort)
+		require.Equal(t, uint16(53), info.DstPort)
+		require.Equal(t, len(b)-3, info.Len)
+		require.Equal(t, "hello", string(info.Payload(b)))
+	})
+
+	t.Run("icmp", func(t *testing.T) {
+		b := ipv4(packet.ICMP, icmp(8, []byte("ping")))
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, uint8(8), info.ICMPType)
+		require.Equal(t, "ping", string(info.Payload(b)))
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		b := ipv4(packet.UDP, []byte("fragment"))
+		header.IPv4(b).SetFlagsFragmentOffset(0, 16)
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.True(t, info.Fragmented)
+		require.Equal(t, uint16(16), info.FragOffset)
+		require.Zero(t, info.SrcPort)
+		require.Equal(t, "fragment", string(info.Payload(b)))
+	})
+
+	t.Run("options", func(t *testing.T) {
+		b := ipv4(packet.UDP, append(make([]byte, 4), udp(nil)...))
+		b[0] = 0x46
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, 24, info.TransportOffset)
+		require.Equal(t, uint16(53), info.DstPort)
+	})
+}
+
+func Test_Parse_IPv6(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		b := ipv6(packet.UDP, nil, udp([]byte("hello")))
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, uint8(6), info.Version)
+		require.Equal(t, src6, info.Src)
+		require.Equal(t, dst6, info.Dst)
+		require.Equal(t, packet.UDP, info.Proto)
+		require.Equal(t, 40, info.TransportOffset)
+		require.Equal(t, "hello", string(info.Payload(b)))
+	})
+
+	t.Run("extension", func(t *testing.T) {
+		var exts = []byte{
+			// hop-by-hop, next routing
+			packet.Routing, 0, 0, 0, 0, 0, 0, 0,
+			// routing, next destination options, length 16 bytes
+			packet.DestOpts, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+			// destination options, next icmpv6
+			packet.ICMPv6, 0, 0, 0, 0, 0, 0, 0,
+		}
+		b := ipv6(packet.HopByHop, exts, icmp(128, []byte("ping")))
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.Equal(t, packet.ICMPv6, info.Proto)
+		require.Equal(t,
+			[]uint8{packet.HopByHop, packet.Routing, packet.DestOpts},
+			info.Exts[:info.NumExts],
+		)
+		require.Equal(t, 40+len(exts), info.TransportOffset)
+		require.Equal(t, uint8(128), info.ICMPType)
+		require.Equal(t, "ping", string(info.Payload(b)))
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		var exts = []byte{packet.TCP, 0, 0, 8 << 3, 0, 0, 0, 1}
+		b := ipv6(packet.Fragment, exts, []byte("fragment"))
+
+		info, err := packet.Parse(b)
+		require.NoError(t, err)
+		require.True(t, info.Fragmented)
+		require.Equal(t, uint16(64), info.FragOffset)
+		require.Equal(t, packet.TCP, info.Proto)
+		require.Equal(t, "fragment", string(info.Payload(b)))
+	})
+
+	t.Run("too-many-extension", func(t *testing.T) {
+		var exts []byte
+		for i := 0; i < 9; i++ {
+			exts = append(exts, packet.DestOpts, 0, 0, 0, 0, 0, 0, 0)
+		}
+		exts[len(exts)-8] = packet.NoNext
+		b := ipv6(packet.DestOpts, exts, nil)
+
+		_, err := packet.Parse(b)
+		require.ErrorIs(t, err, packet.ErrInvalidHeader{})
+	})
+}
+
+func Test_Parse_Invalid(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		{0x45},
+		{0x00, 0, 0, 20},
+		ipv4(packet.TCP, tcp(nil))[:30],
+		ipv4(packet.UDP, udp(nil))[:24],
+		ipv6(packet.UDP, nil, udp(nil))[:44],
+	} {
+		_, err := packet.Parse(b)
+		require.Error(t, err, b)
+	}
+}
+
+func Fuzz_Parse(f *testing.F) {
+	f.Add(ipv4(packet.TCP, tcp([]byte("hello"))))
+	f.Add(ipv4(packet.UDP, udp([]byte("hello"))))
+	f.Add(ipv4(packet.ICMP, icmp(8, nil)))
+	f.Add(ipv6(packet.UDP, nil, udp([]byte("hello"))))
+	f.Add(ipv6(packet.Fragment, []byte{packet.TCP, 0, 0, 1, 0, 0, 0, 1}, tcp(nil)))
+	f.Add(ipv6(packet.HopByHop, []byte{packet.ICMPv6, 0, 0, 0, 0, 0, 0, 0}, icmp(128, nil)))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		info, err := packet.Parse(b)
+		if err != nil {
+			return
+		}
+		require.True(t, info.Version == 4 || info.Version == 6)
+		require.LessOrEqual(t, info.TransportOffset, info.PayloadOffset)
+		require.LessOrEqual(t, info.PayloadOffset, info.Len)
+		require.LessOrEqual(t, info.Len, len(b))
+		require.LessOrEqual(t, info.NumExts, len(info.Exts))
+		_ = info.Payload(b)
+	})
+}
+
+func Benchmark_Parse(b *testing.B) {
+	p := ipv6(packet.HopByHop, []byte{packet.TCP, 0, 0, 0, 0, 0, 0, 0}, tcp(make([]byte, 1400)))
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := packet.Parse(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
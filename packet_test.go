@@ -0,0 +1,95 @@
+package wintun
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SizeClass(t *testing.T) {
+	for _, e := range []struct{ size, class int }{
+		{0, 0}, {1, 0}, {128, 0}, {129, 1}, {256, 1},
+		{1500, 4}, {2048, 4}, {0xffff, len(pools) - 1}, {0x10000, len(pools) - 1},
+	} {
+		require.Equal(t, e.class, sizeClass(e.size), e.size)
+	}
+}
+
+func Test_Packet(t *testing.T) {
+	for _, size := range []int{0, 20, 1500, MaxPacketSize, MaxPacketSize + 1} {
+		pkt := NewPacket(size)
+		require.Equal(t, min(size, MaxPacketSize), len(pkt.Bytes()))
+		pkt.Release()
+	}
+
+	var zero Packet
+	require.Nil(t, zero.Bytes())
+	zero.Release()
+}
+
+func Test_ReadPacket(t *testing.T) {
+	dev := newMemDevice(1)
+	ip := buildUDP(
+		netip.MustParseAddrPort("10.0.0.1:1234"),
+		netip.MustParseAddrPort("10.0.0.2:80"),
+		[]byte("hello"),
+	)
+	dev.in <- ip
+
+	pkt, err := ReadPacket(context.Background(), dev)
+	require.NoError(t, err)
+	defer pkt.Release()
+
+	require.Equal(t, ip, pkt.Bytes())
+	require.Zero(t, dev.outstanding())
+}
+
+func Benchmark_Packet(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		pkt := NewPacket(1500)
+		pkt.Release()
+	}
+}
+
+func Benchmark_ReadPacket(b *testing.B) {
+	dev := newMemDevice(1)
+	ip := buildUDP(
+		netip.MustParseAddrPort("10.0.0.1:1234"),
+		netip.MustParseAddrPort("10.0.0.2:80"),
+		make([]byte, 1400),
+	)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dev.in <- ip
+		pkt, err := ReadPacket(ctx, dev)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pkt.Release()
+	}
+}
+
+func Benchmark_Pump(b *testing.B) {
+	dev := newMemDevice(64)
+	ip := buildUDP(
+		netip.MustParseAddrPort("10.0.0.1:1234"),
+		netip.MustParseAddrPort("10.0.0.2:80"),
+		make([]byte, 1400),
+	)
+	p := NewPump(dev, PumpConfig{})
+	defer p.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dev.in <- ip
+		pkt := <-p.Recv()
+		pkt.Release()
+	}
+}
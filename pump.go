@@ -0,0 +1,258 @@
+package wintun
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Policy how to handle packet when consumer falls behind
+type Policy int
+
+const (
+	// Block wait for consumer
+	Block Policy = iota
+	// DropOldest drop the oldest queued packet
+	DropOldest
+	// DropNewest drop the new packet
+	DropNewest
+)
+
+type PumpConfig struct {
+	// size of Recv and Send channel, default 64
+	Size int
+
+	// Policy of Recv channel full, and Send when ring full, the Send packet
+	// is retried if Block, otherwise dropped. default Block
+	Policy Policy
+}
+
+func (c *PumpConfig) init() {
+	if c.Size <= 0 {
+		c.Size = 64
+	}
+}
+
+// Pump pump packets between Device and channels, the packets are copied to
+// pooled buffer.
+type Pump struct {
+	dev Device
+	cfg PumpConfig
+
+	recv chan Packet
+	// in unbuffered, only acceptService receive from it, so no packet can be
+	// sent after acceptService exited
+	in   chan Packet
+	send chan Packet
+	// closed when sendService exited
+	sendDone chan struct{}
+	// closed when acceptService exited
+	done chan struct{}
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	dropped atomic.Uint64
+
+	errMu sync.Mutex
+	err   error
+}
+
+// NewPump start pump dev, must call Close after used, the Pump take over the
+// Recv/Release and Alloc/Send of dev.
+func NewPump(dev Device, cfg PumpConfig) *Pump {
+	cfg.init()
+
+	var p = &Pump{
+		dev:      dev,
+		cfg:      cfg,
+		recv:     make(chan Packet, cfg.Size),
+		in:       make(chan Packet),
+		send:     make(chan Packet, cfg.Size),
+		sendDone: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	p.wg.Add(3)
+	go p.recvService()
+	go p.acceptService()
+	go p.sendService()
+	return p
+}
+
+// Recv received packets, must Release the packet after used, the channel will
+// be closed when pump closed or device receive failed, see Err.
+func (p *Pump) Recv() <-chan Packet { return p.recv }
+
+// Send channel of packets to send, the sent packet is released by pump. the
+// send never succeed after Done closed, so select it with Done, and release
+// the not sent packet:
+//
+//	select {
+//	case p.Send() <- pkt:
+//	case <-p.Done():
+//		pkt.Release()
+//	}
+func (p *Pump) Send() chan<- Packet { return p.in }
+
+// Done closed when pump can't send packet anymore, by Close or the device
+// send failed, see Err.
+func (p *Pump) Done() <-chan struct{} { return p.done }
+
+// Dropped dropped packets count by Policy
+func (p *Pump) Dropped() uint64 { return p.dropped.Load() }
+
+// Err the error cause pump stopped, nil if not stopped or closed by Close
+func (p *Pump) Err() error {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	return p.err
+}
+
+func (p *Pump) setErr(err error) {
+	if p.ctx.Err() != nil {
+		return
+	}
+	p.errMu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.errMu.Unlock()
+}
+
+func (p *Pump) recvService() {
+	defer p.wg.Done()
+	defer close(p.recv)
+
+	for {
+		pkt, err := ReadPacket(p.ctx, p.dev)
+		if err != nil {
+			p.setErr(err)
+			return
+		}
+
+		if !p.deliver(pkt) {
+			pkt.Release()
+			return
+		}
+	}
+}
+
+// deliver packet to Recv channel by Policy, return false if pump closed
+func (p *Pump) deliver(pkt Packet) bool {
+	switch p.cfg.Policy {
+	case DropNewest:
+		select {
+		case p.recv <- pkt:
+		default:
+			pkt.Release()
+			p.dropped.Add(1)
+		}
+		return true
+	case DropOldest:
+		for {
+			select {
+			case p.recv <- pkt:
+				return true
+			default:
+			}
+			select {
+			case old := <-p.recv:
+				old.Release()
+				p.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case p.recv <- pkt:
+			return true
+		case <-p.ctx.Done():
+			return false
+		}
+	}
+}
+
+// acceptService move packets from Send channel to the bounded send queue
+func (p *Pump) acceptService() {
+	defer p.wg.Done()
+	defer close(p.done)
+
+	for {
+		select {
+		case pkt := <-p.in:
+			select {
+			case p.send <- pkt:
+			case <-p.sendDone:
+				pkt.Release()
+				return
+			case <-p.ctx.Done():
+				pkt.Release()
+				return
+			}
+		case <-p.sendDone:
+			return
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Pump) sendService() {
+	defer p.wg.Done()
+	defer close(p.sendDone)
+
+	for {
+		select {
+		case pkt := <-p.send:
+			err := p.write(pkt)
+			pkt.Release()
+			if err != nil {
+				p.setErr(err)
+				return
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Pump) write(pkt Packet) error {
+	for {
+		err := WritePacket(p.dev, pkt.Bytes())
+		if !errors.Is(err, ErrRingFull{}) {
+			return err
+		} else if p.cfg.Policy != Block {
+			p.dropped.Add(1)
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Millisecond):
+		case <-p.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Close stop pump, and release the queued packets
+func (p *Pump) Close() error {
+	p.cancel()
+	p.wg.Wait()
+
+	for pkt := range p.recv {
+		pkt.Release()
+	}
+	for {
+		select {
+		case pkt := <-p.send:
+			pkt.Release()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package wintun
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Pump(t *testing.T) {
+	var (
+		src = netip.MustParseAddrPort("10.0.0.1:1234")
+		dst = netip.MustParseAddrPort("10.0.0.2:80")
+	)
+	waitRecved := func(dev *memDevice, n int64) {
+		for dev.recved.Load() < n || dev.outstanding() > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("recv-send", func(t *testing.T) {
+		dev := newMemDevice(8)
+		p := NewPump(dev, PumpConfig{})
+		defer p.Close()
+
+		ip := buildUDP(src, dst, []byte("hello"))
+		dev.in <- ip
+
+		pkt := <-p.Recv()
+		require.Equal(t, ip, pkt.Bytes())
+		p.Send() <- pkt
+
+		require.Equal(t, ip, <-dev.out)
+		require.Zero(t, dev.outstanding())
+	})
+
+	t.Run("drop-newest", func(t *testing.T) {
+		dev := newMemDevice(16)
+		p := NewPump(dev, PumpConfig{Size: 2, Policy: DropNewest})
+
+		for i := 0; i < 10; i++ {
+			dev.in <- buildUDP(src, dst, []byte{byte(i)})
+		}
+		waitRecved(dev, 10)
+		require.Equal(t, uint64(8), p.Dropped())
+
+		for i := 0; i < 2; i++ {
+			pkt := <-p.Recv()
+			require.Equal(t, byte(i), pkt.Bytes()[28])
+			pkt.Release()
+		}
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("drop-oldest", func(t *testing.T) {
+		dev := newMemDevice(16)
+		p := NewPump(dev, PumpConfig{Size: 2, Policy: DropOldest})
+
+		for i := 0; i < 10; i++ {
+			dev.in <- buildUDP(src, dst, []byte{byte(i)})
+		}
+		waitRecved(dev, 10)
+		require.Equal(t, uint64(8), p.Dropped())
+
+		for i := 8; i < 10; i++ {
+			pkt := <-p.Recv()
+			require.Equal(t, byte(i), pkt.Bytes()[28])
+			pkt.Release()
+		}
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("block", func(t *testing.T) {
+		dev := newMemDevice(16)
+		p := NewPump(dev, PumpConfig{Size: 2, Policy: Block})
+
+		for i := 0; i < 10; i++ {
+			dev.in <- buildUDP(src, dst, []byte{byte(i)})
+		}
+		for i := 0; i < 10; i++ {
+			pkt := <-p.Recv()
+			require.Equal(t, byte(i), pkt.Bytes()[28])
+			pkt.Release()
+		}
+		require.Zero(t, p.Dropped())
+		require.NoError(t, p.Close())
+	})
+
+	t.Run("close", func(t *testing.T) {
+		dev := newMemDevice(16)
+		p := NewPump(dev, PumpConfig{Size: 4})
+
+		for i := 0; i < 10; i++ {
+			dev.in <- buildUDP(src, dst, []byte{byte(i)})
+		}
+		waitRecved(dev, 5)
+
+		require.NoError(t, p.Close())
+		require.Zero(t, dev.outstanding())
+		require.NoError(t, p.Err())
+
+		_, ok := <-p.Recv()
+		require.False(t, ok)
+
+		<-p.Done()
+		select {
+		case p.Send() <- NewPacket(64):
+			t.Fatal("send after close")
+		default:
+		}
+	})
+
+	t.Run("recv-error", func(t *testing.T) {
+		dev := newMemDevice(1)
+		p := NewPump(dev, PumpConfig{})
+		defer p.Close()
+
+		close(dev.in)
+		_, ok := <-p.Recv()
+		require.False(t, ok)
+		require.ErrorIs(t, p.Err(), ErrAdapterClosed{})
+	})
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package relay
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/pkg/errors"
+)
+
+func unreachableReason(err error) (forward.Unreachable, bool) {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return 0, false
+	}
+	switch errno {
+	case syscall.ENETUNREACH:
+		return forward.NetUnreachable, true
+	case syscall.EHOSTUNREACH:
+		return forward.HostUnreachable, true
+	case syscall.ECONNREFUSED:
+		return forward.PortUnreachable, true
+	case syscall.EACCES, syscall.EPERM:
+		return forward.AdminProhibited, true
+	default:
+		return 0, false
+	}
+}
+
+// enableReset the icmp errors are always reported by connected udp socket
+func enableReset(conn net.Conn) error { return nil }
@@ -0,0 +1,76 @@
+//go:build windows
+// +build windows
+
+package relay
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/winsock/winsock-ioctls
+const sioUDPNetReset = windows.IOC_IN | windows.IOC_VENDOR | 15
+
+// enableReset re-enable SIO_UDP_CONNRESET and SIO_UDP_NETRESET of udp socket,
+// so the received ICMP port/net unreachable are reported as WSAECONNRESET and
+// WSAENETRESET. go disable them after Dialer.Control called, so they can only
+// be enabled on the dialed conn.
+func enableReset(conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var e error
+	err = rc.Control(func(fd uintptr) {
+		for _, ioc := range []uint32{windows.SIO_UDP_CONNRESET, sioUDPNetReset} {
+			var flag, ret uint32 = 1, 0
+			e = windows.WSAIoctl(
+				windows.Handle(fd), ioc,
+				(*byte)(unsafe.Pointer(&flag)), uint32(unsafe.Sizeof(flag)),
+				nil, 0, &ret, nil, 0,
+			)
+			if e != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(e)
+}
+
+func unreachableReason(err error) (forward.Unreachable, bool) {
+	var errno windows.Errno
+	if !errors.As(err, &errno) {
+		return 0, false
+	}
+	switch errno {
+	case windows.WSAENETUNREACH:
+		return forward.NetUnreachable, true
+	case windows.WSAEHOSTUNREACH:
+		return forward.HostUnreachable, true
+	case windows.WSAECONNREFUSED:
+		return forward.PortUnreachable, true
+	case windows.WSAECONNRESET:
+		// connected udp socket receive port unreachable
+		return forward.PortUnreachable, true
+	case windows.WSAENETRESET:
+		// udp socket receive net unreachable
+		return forward.NetUnreachable, true
+	case windows.WSAEACCES:
+		return forward.AdminProhibited, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,80 @@
+// Package relay direct egress, re-originate the flows terminated by forward
+// package from host by normal sockets, usually bound to physical interface
+// to avoid routing loop through adapter.
+package relay
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/internal/bind"
+	"github.com/pkg/errors"
+)
+
+// Dialer *net.Dialer implement it
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Direct egress dial destination by Dialer, it implement forward.Egress, the
+// unreachable errors are reported as forward.ErrUnreachable.
+type Direct struct {
+	// Dialer default *net.Dialer, reference BindDialer
+	Dialer Dialer
+}
+
+var _ forward.Egress = (*Direct)(nil)
+
+func (d *Direct) dialer() Dialer {
+	if d.Dialer == nil {
+		return &net.Dialer{}
+	}
+	return d.Dialer
+}
+
+func (d *Direct) DialTCP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	conn, err := d.dialer().DialContext(ctx, "tcp", dst.String())
+	if err != nil {
+		return nil, errors.WithStack(unreachable(err))
+	}
+	return conn, nil
+}
+
+func (d *Direct) DialUDP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	conn, err := d.dialer().DialContext(ctx, "udp", dst.String())
+	if err != nil {
+		return nil, errors.WithStack(unreachable(err))
+	}
+	if err := enableReset(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &udpConn{Conn: conn}, nil
+}
+
+// BindDialer get dialer that bind sockets to interface by index, so the
+// connections not routed through adapter
+func BindDialer(index int) *net.Dialer { return bind.Dialer(index) }
+
+// udpConn report the icmp errors received by connected udp socket
+type udpConn struct {
+	net.Conn
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		return n, unreachable(err)
+	}
+	return n, nil
+}
+
+// unreachable convert unreachable error to forward.ErrUnreachable
+func unreachable(err error) error {
+	if reason, ok := unreachableReason(err); ok {
+		return forward.ErrUnreachable{Reason: reason, Err: err}
+	}
+	return err
+}
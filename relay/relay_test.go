@@ -0,0 +1,199 @@
+package relay_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/internal/stacktest"
+	"github.com/lysShub/wintun-go/relay"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	host = netip.MustParseAddr("10.6.7.8")
+	dst  = netip.MustParseAddrPort("203.0.113.1:80")
+)
+
+// rewrite redirect all destinations to local address
+type rewrite struct {
+	relay.Dialer
+	tcp, udp string
+}
+
+func (r *rewrite) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network == "tcp" {
+		address = r.tcp
+	} else {
+		address = r.udp
+	}
+	return r.Dialer.DialContext(ctx, network, address)
+}
+
+func echoServers(t *testing.T) (tcp, udp string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+
+	u, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { u.Close() })
+	go func() {
+		var b = make([]byte, 0xffff)
+		for {
+			n, addr, err := u.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			u.WriteTo(b[:n], addr)
+		}
+	}()
+	return l.Addr().String(), u.LocalAddr().String()
+}
+
+// closedPorts get local tcp and udp addresses that nobody listen
+func closedPorts(t *testing.T) (tcp, udp string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcp = l.Addr().String()
+	l.Close()
+
+	u, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	udp = u.LocalAddr().String()
+	u.Close()
+	return tcp, udp
+}
+
+func setup(t *testing.T, d relay.Dialer) *stacktest.Host {
+	h, err := stacktest.New(1500, host)
+	require.NoError(t, err)
+
+	f, err := forward.New(h, &relay.Direct{Dialer: d}, forward.Config{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go f.Serve(ctx)
+	t.Cleanup(func() {
+		cancel()
+		f.Close()
+		h.Close()
+	})
+	return h
+}
+
+func Test_Direct(t *testing.T) {
+	tcp, udp := echoServers(t)
+	h := setup(t, &rewrite{Dialer: &net.Dialer{}, tcp: tcp, udp: udp})
+
+	t.Run("tcp", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		conn, err := h.DialTCP(ctx, dst)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		go func() {
+			conn.Write([]byte("hello"))
+			conn.(interface{ CloseWrite() error }).CloseWrite()
+		}()
+		got, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(got))
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := h.DialUDP(dst)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		var b = make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b[:n]))
+	})
+}
+
+func Test_Direct_Unreachable(t *testing.T) {
+	tcp, udp := closedPorts(t)
+	h := setup(t, &rewrite{Dialer: &net.Dialer{}, tcp: tcp, udp: udp})
+
+	t.Run("tcp", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		_, err := h.DialTCP(ctx, dst)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "refused")
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := h.DialUDP(dst)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// ICMP port unreachable replied to host, the error is reported by
+		// next socket operation
+		for i := 0; i < 10; i++ {
+			if _, err = conn.Write([]byte("hello")); err != nil {
+				break
+			}
+			conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+			_, err = conn.Read(make([]byte, 64))
+			if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+				break
+			}
+		}
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "refused")
+	})
+}
+
+func Test_BindDialer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("require root")
+	}
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("not loopback interface")
+	}
+
+	tcp, udp := echoServers(t)
+	h := setup(t, &rewrite{Dialer: relay.BindDialer(lo.Index), tcp: tcp, udp: udp})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	conn, err := h.DialTCP(ctx, dst)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	var b = make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	// bind to invalid interface
+	_, err = relay.BindDialer(1<<20).Dial("tcp", tcp)
+	require.Error(t, err)
+}
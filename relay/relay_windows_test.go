@@ -0,0 +1,43 @@
+//go:build windows
+// +build windows
+
+package relay_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/relay"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Direct_ConnReset(t *testing.T) {
+	_, udp := closedPorts(t)
+
+	d := &relay.Direct{}
+	conn, err := d.DialUDP(context.Background(), netip.AddrPort{}, netip.MustParseAddrPort(udp))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// go disable SIO_UDP_CONNRESET by default, the ICMP port unreachable
+	// is only reported after re-enabled
+	for i := 0; i < 10; i++ {
+		if _, err = conn.Write([]byte("hello")); err != nil {
+			break
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+		_, err = conn.Read(make([]byte, 64))
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			break
+		}
+	}
+
+	var e forward.ErrUnreachable
+	require.True(t, errors.As(err, &e), err)
+	require.Equal(t, forward.PortUnreachable, e.Reason)
+}
@@ -0,0 +1,51 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Adapter_Resize(t *testing.T) {
+	MustLoad(DLL)
+
+	ap, err := CreateAdapter("testadapterresize", RingBuff(MinRingCapacity))
+	require.NoError(t, err)
+	defer ap.Close()
+
+	t.Run("held-packet", func(t *testing.T) {
+		p, err := ap.Alloc(64)
+		require.NoError(t, err)
+
+		// not released within timeout, the restart is skipped
+		require.NoError(t, ap.resize(MinRingCapacity*2, time.Millisecond*100))
+		require.Equal(t, uint32(MinRingCapacity), ap.Capacity())
+
+		// session is still usable
+		require.NoError(t, ap.Send(p))
+		p, err = ap.Alloc(64)
+		require.NoError(t, err)
+
+		resized := make(chan error, 1)
+		go func() { resized <- ap.resize(MinRingCapacity*2, time.Second*5) }()
+		time.Sleep(time.Millisecond * 100)
+		require.NoError(t, ap.Send(p))
+		require.NoError(t, <-resized)
+		require.Equal(t, uint32(MinRingCapacity*2), ap.Capacity())
+
+		p, err = ap.Alloc(64)
+		require.NoError(t, err)
+		require.NoError(t, ap.Send(p))
+	})
+
+	t.Run("restart-previous", func(t *testing.T) {
+		// start with invalid capacity failed, fallback to previous capacity
+		err := ap.resize(MaxRingCapacity*2, time.Second)
+		require.Error(t, err)
+		require.Equal(t, uint32(MinRingCapacity*2), ap.Capacity())
+	})
+}
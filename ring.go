@@ -0,0 +1,136 @@
+package wintun
+
+import (
+	"log/slog"
+	"math/bits"
+	"time"
+)
+
+const (
+	// every packet in ring has a size header, and aligned to 4 bytes
+	packetHeaderSize = 4
+	packetAlignment  = 4
+)
+
+// RingCapacity compute the ring capacity that can buffer burst duration traffic
+// of bandwidth (bytes per second) with mtu sized packets, the result is power
+// of two between MinRingCapacity and MaxRingCapacity.
+func RingCapacity(bandwidth uint64, burst time.Duration, mtu int) uint32 {
+	if mtu <= 0 {
+		mtu = 1500
+	}
+	bytes := float64(bandwidth) * burst.Seconds()
+	packets := uint64(bytes/float64(mtu)) + 1
+	packetSize := uint64(packetHeaderSize + (mtu+packetAlignment-1)&^(packetAlignment-1))
+
+	need := packets * packetSize
+	if need <= MinRingCapacity {
+		return MinRingCapacity
+	} else if need >= MaxRingCapacity {
+		return MaxRingCapacity
+	}
+	return 1 << bits.Len64(need-1)
+}
+
+// Stats adapter session statistics, the counters are accumulated since adapter
+// created
+type Stats struct {
+	// received packets
+	Recv uint64
+	// times of Recv waiting for ring read event, it means the receive ring
+	// is empty
+	RecvWait uint64
+	// allocated send packets
+	Alloc uint64
+	// times of Alloc failed by send ring full
+	RingFull uint64
+}
+
+// AdaptiveConfig adaptive ring capacity config, the session will be restarted
+// with double capacity when sustained pressure is detected, pressure means in a
+// sample interval:
+//   - ratio of Alloc failed by ring full not less than FullRatio, or
+//   - received not less than BusyRecv packets without waiting, it means the
+//     receive ring is always backlogged
+//
+// restart session will drop the packets in ring, and it's only performed after
+// all received/allocated packets released, if they are not released within
+// Interval, the restart is skipped.
+type AdaptiveConfig struct {
+	// sample interval, default 1s
+	Interval time.Duration
+	// consecutive pressure intervals before grow, default 3
+	Sustain int
+	// default 0.01
+	FullRatio float64
+	// default 1024
+	BusyRecv uint64
+	// maximum ring capacity, default MaxRingCapacity
+	Max uint32
+	// OnError called when restart session failed, if the previous capacity also
+	// can't be restarted, the adapter is stopped. default log by slog.Default()
+	OnError func(error)
+}
+
+func (c *AdaptiveConfig) init() {
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.Sustain <= 0 {
+		c.Sustain = 3
+	}
+	if c.FullRatio <= 0 {
+		c.FullRatio = 0.01
+	}
+	if c.BusyRecv == 0 {
+		c.BusyRecv = 1024
+	}
+	if c.Max == 0 || c.Max > MaxRingCapacity {
+		c.Max = MaxRingCapacity
+	} else if c.Max < MinRingCapacity {
+		c.Max = MinRingCapacity
+	}
+	c.Max = 1 << (bits.Len32(c.Max) - 1)
+	if c.OnError == nil {
+		c.OnError = func(err error) {
+			slog.Default().Error("wintun adaptive ring", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// ringSizer detect sustained pressure from Stats samples
+type ringSizer struct {
+	cfg  AdaptiveConfig
+	last Stats
+	hits int
+}
+
+func newRingSizer(cfg AdaptiveConfig) *ringSizer {
+	cfg.init()
+	return &ringSizer{cfg: cfg}
+}
+
+// observe a new sample, return the grown capacity if sustained pressure detected
+func (r *ringSizer) observe(s Stats, capacity uint32) (grow uint32, ok bool) {
+	var (
+		recv     = s.Recv - r.last.Recv
+		wait     = s.RecvWait - r.last.RecvWait
+		alloc    = s.Alloc - r.last.Alloc
+		ringFull = s.RingFull - r.last.RingFull
+	)
+	r.last = s
+
+	pressure := (ringFull > 0 && float64(ringFull)/float64(alloc+ringFull) >= r.cfg.FullRatio) ||
+		(wait == 0 && recv >= r.cfg.BusyRecv)
+	if !pressure {
+		r.hits = 0
+		return 0, false
+	}
+
+	r.hits++
+	if r.hits < r.cfg.Sustain || capacity >= r.cfg.Max {
+		return 0, false
+	}
+	r.hits = 0
+	return min(capacity*2, r.cfg.Max), true
+}
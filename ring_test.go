@@ -0,0 +1,89 @@
+package wintun
+
+import (
+	"math/bits"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RingCapacity(t *testing.T) {
+	t.Run("bound", func(t *testing.T) {
+		require.Equal(t, uint32(MinRingCapacity), RingCapacity(0, 0, 1500))
+		require.Equal(t, uint32(MinRingCapacity), RingCapacity(1<<10, time.Millisecond, 1500))
+		require.Equal(t, uint32(MaxRingCapacity), RingCapacity(10<<30, time.Second, 1500))
+	})
+
+	t.Run("power-of-two", func(t *testing.T) {
+		// 100MB/s, 20ms burst: 2MB
+		c := RingCapacity(100<<20, time.Millisecond*20, 1500)
+		require.Equal(t, 1, bits.OnesCount32(c))
+		require.GreaterOrEqual(t, c, uint32(100<<20/50))
+		require.NoError(t, validateCapacity(c))
+	})
+
+	t.Run("overhead", func(t *testing.T) {
+		// small packets occupy more ring space
+		require.Greater(t,
+			RingCapacity(1500*1000, time.Second, 4),
+			RingCapacity(1500*1000, time.Second, 1500),
+		)
+	})
+}
+
+func Test_RingSizer(t *testing.T) {
+	t.Run("ring-full", func(t *testing.T) {
+		r := newRingSizer(AdaptiveConfig{Sustain: 2})
+
+		var s Stats
+		s.Alloc, s.RingFull = 100, 10
+		_, ok := r.observe(s, MinRingCapacity)
+		require.False(t, ok)
+
+		s.Alloc, s.RingFull = 200, 20
+		grow, ok := r.observe(s, MinRingCapacity)
+		require.True(t, ok)
+		require.Equal(t, uint32(MinRingCapacity*2), grow)
+	})
+
+	t.Run("not-sustained", func(t *testing.T) {
+		r := newRingSizer(AdaptiveConfig{Sustain: 2})
+
+		var s Stats
+		s.Alloc, s.RingFull = 100, 10
+		_, ok := r.observe(s, MinRingCapacity)
+		require.False(t, ok)
+
+		s.Alloc = 200
+		_, ok = r.observe(s, MinRingCapacity)
+		require.False(t, ok)
+
+		s.Alloc, s.RingFull = 300, 20
+		_, ok = r.observe(s, MinRingCapacity)
+		require.False(t, ok)
+	})
+
+	t.Run("recv-busy", func(t *testing.T) {
+		r := newRingSizer(AdaptiveConfig{Sustain: 1, BusyRecv: 10})
+
+		_, ok := r.observe(Stats{Recv: 100, RecvWait: 1}, MinRingCapacity)
+		require.False(t, ok)
+
+		grow, ok := r.observe(Stats{Recv: 200, RecvWait: 1}, MinRingCapacity)
+		require.True(t, ok)
+		require.Equal(t, uint32(MinRingCapacity*2), grow)
+	})
+
+	t.Run("max", func(t *testing.T) {
+		r := newRingSizer(AdaptiveConfig{Sustain: 1, Max: MinRingCapacity*3 - 1})
+		require.Equal(t, uint32(MinRingCapacity*2), r.cfg.Max)
+
+		grow, ok := r.observe(Stats{Alloc: 1, RingFull: 1}, MinRingCapacity)
+		require.True(t, ok)
+		require.Equal(t, uint32(MinRingCapacity*2), grow)
+
+		_, ok = r.observe(Stats{Alloc: 2, RingFull: 2}, grow)
+		require.False(t, ok)
+	})
+}
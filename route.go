@@ -0,0 +1,70 @@
+package wintun
+
+import (
+	"net/netip"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRoutes the routes override default route without replacing it, the
+// two halves of address space are more specific than default route
+func DefaultRoutes(v4, v6 bool) []netip.Prefix {
+	var routes []netip.Prefix
+	if v4 {
+		routes = append(routes,
+			netip.MustParsePrefix("0.0.0.0/1"),
+			netip.MustParsePrefix("128.0.0.0/1"),
+		)
+	}
+	if v6 {
+		routes = append(routes,
+			netip.MustParsePrefix("::/1"),
+			netip.MustParsePrefix("8000::/1"),
+		)
+	}
+	return routes
+}
+
+// route entry of system route table
+type route struct {
+	dst     netip.Prefix
+	nextHop netip.Addr
+	iface   uint64 // interface luid
+	metric  uint32 // route metric plus interface metric
+}
+
+// lookupRoute find route of dst like system, the longest prefix with lowest
+// metric wins, the routes on interface exclude are ignored
+func lookupRoute(table []route, dst netip.Addr, exclude uint64) (route, bool) {
+	var (
+		best  route
+		found bool
+	)
+	dst = dst.Unmap()
+	for _, r := range table {
+		if r.iface == exclude || !r.dst.Contains(dst) {
+			continue
+		}
+		if !found || r.dst.Bits() > best.dst.Bits() ||
+			(r.dst.Bits() == best.dst.Bits() && r.metric < best.metric) {
+			best, found = r, true
+		}
+	}
+	return best, found
+}
+
+// validateRoute route destination must be valid and masked prefix
+func validateRoute(dst netip.Prefix) error {
+	if !dst.IsValid() || dst.Addr().Is4In6() {
+		return errors.Errorf("invalid route destination %s", dst)
+	} else if dst.Masked() != dst {
+		return errors.Errorf("route destination %s not masked", dst)
+	}
+	return nil
+}
+
+// hostRoute the route only contain addr
+func hostRoute(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
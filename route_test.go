@@ -0,0 +1,79 @@
+package wintun
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DefaultRoutes(t *testing.T) {
+	routes := DefaultRoutes(true, true)
+	require.Len(t, routes, 4)
+
+	// the halves cover whole address space, and prior to default route
+	for _, s := range []string{"0.0.0.0", "127.255.255.255", "128.0.0.0", "255.255.255.255", "::", "8000::", "ffff::1"} {
+		addr := netip.MustParseAddr(s)
+		var n int
+		for _, r := range routes {
+			if r.Contains(addr) {
+				require.Greater(t, r.Bits(), 0)
+				n++
+			}
+		}
+		require.Equal(t, 1, n, s)
+	}
+
+	require.Empty(t, DefaultRoutes(false, false))
+	require.Equal(t, routes[:2], DefaultRoutes(true, false))
+	require.Equal(t, routes[2:], DefaultRoutes(false, true))
+}
+
+func Test_LookupRoute(t *testing.T) {
+	const tun, eth, wifi = 1, 2, 3
+	var table = []route{
+		{dst: netip.MustParsePrefix("0.0.0.0/0"), nextHop: netip.MustParseAddr("192.168.1.1"), iface: eth, metric: 35},
+		{dst: netip.MustParsePrefix("0.0.0.0/0"), nextHop: netip.MustParseAddr("192.168.2.1"), iface: wifi, metric: 50},
+		{dst: netip.MustParsePrefix("192.168.2.0/24"), iface: wifi, metric: 50},
+		{dst: netip.MustParsePrefix("0.0.0.0/1"), iface: tun, metric: 5},
+		{dst: netip.MustParsePrefix("128.0.0.0/1"), iface: tun, metric: 5},
+		{dst: netip.MustParsePrefix("::/0"), nextHop: netip.MustParseAddr("fe80::1"), iface: eth, metric: 35},
+	}
+
+	for _, c := range []struct {
+		dst     string
+		exclude uint64
+		iface   uint64
+		nextHop string
+	}{
+		{"1.2.3.4", tun, eth, "192.168.1.1"},
+		{"::ffff:1.2.3.4", tun, eth, "192.168.1.1"},
+		{"192.168.2.9", tun, wifi, ""},
+		{"1.2.3.4", 0, tun, ""},
+		{"1.2.3.4", eth, tun, ""},
+		{"2001:db8::1", tun, eth, "fe80::1"},
+	} {
+		r, ok := lookupRoute(table, netip.MustParseAddr(c.dst), c.exclude)
+		require.True(t, ok, c.dst)
+		require.Equal(t, c.iface, r.iface, c.dst)
+		if c.nextHop != "" {
+			require.Equal(t, netip.MustParseAddr(c.nextHop), r.nextHop, c.dst)
+		} else {
+			require.False(t, r.nextHop.IsValid(), c.dst)
+		}
+	}
+
+	_, ok := lookupRoute(table[3:5], netip.MustParseAddr("1.2.3.4"), tun)
+	require.False(t, ok)
+}
+
+func Test_ValidateRoute(t *testing.T) {
+	require.NoError(t, validateRoute(netip.MustParsePrefix("10.0.0.0/8")))
+	require.NoError(t, validateRoute(netip.MustParsePrefix("fd00::/8")))
+	require.Error(t, validateRoute(netip.Prefix{}))
+	require.Error(t, validateRoute(netip.MustParsePrefix("10.0.0.1/8")))
+	require.Error(t, validateRoute(netip.MustParsePrefix("::ffff:10.0.0.0/104")))
+
+	require.Equal(t, netip.MustParsePrefix("10.0.0.1/32"), hostRoute(netip.MustParseAddr("::ffff:10.0.0.1")))
+	require.Equal(t, netip.MustParsePrefix("fd00::1/128"), hostRoute(netip.MustParseAddr("fd00::1")))
+}
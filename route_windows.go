@@ -0,0 +1,364 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+type RouteConfig struct {
+	// Metric of added routes
+	Metric uint32
+
+	// Lease lifetime of added routes, they are refreshed before expired, so
+	// that they are removed by system after process exit, default 1min,
+	// minimum 1s
+	Lease time.Duration
+
+	// OnError called when refresh route failed, the route may be expired.
+	// default log by slog.Default()
+	OnError func(error)
+}
+
+func (c *RouteConfig) init() {
+	if c.Lease <= 0 {
+		c.Lease = time.Minute
+	} else if c.Lease < time.Second {
+		c.Lease = time.Second
+	}
+	if c.OnError == nil {
+		c.OnError = func(err error) {
+			slog.Default().Error("wintun route refresh", slog.String("error", err.Error()))
+		}
+	}
+}
+
+type ErrRouteManagerClosed struct{}
+
+func (ErrRouteManagerClosed) Error() string { return "route manager closed" }
+
+type routeKey struct {
+	luid    winipcfg.LUID
+	dst     netip.Prefix
+	nextHop netip.Addr
+}
+
+// RouteManager manage the routes added for adapter, it tracks added routes
+// and removes them when Close or adapter Close
+type RouteManager struct {
+	a    *Adapter
+	luid winipcfg.LUID
+	cfg  RouteConfig
+
+	mu     sync.Mutex
+	routes map[routeKey]*winipcfg.MibIPforwardRow2
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// RouteManager create route manager of adapter, it is closed when adapter
+// Close
+func (a *Adapter) RouteManager(cfg RouteConfig) (*RouteManager, error) {
+	cfg.init()
+	luid, err := a.GetAdapterLuid()
+	if err != nil {
+		return nil, err
+	}
+
+	var m = &RouteManager{
+		a:      a,
+		luid:   luid,
+		cfg:    cfg,
+		routes: map[routeKey]*winipcfg.MibIPforwardRow2{},
+		done:   make(chan struct{}),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.handle == 0 || a.closed.Load() {
+		return nil, errors.WithStack(ErrAdapterClosed{})
+	}
+	a.routes = append(a.routes, m)
+
+	m.wg.Add(1)
+	go m.refresh()
+	return m, nil
+}
+
+// Add add on-link routes to adapter
+func (m *RouteManager) Add(dsts ...netip.Prefix) error {
+	for _, dst := range dsts {
+		if err := validateRoute(dst); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dst := range dsts {
+		if err := m.addLocked(routeKey{luid: m.luid, dst: dst, nextHop: unspecified(dst.Addr())}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete delete routes added by Add
+func (m *RouteManager) Delete(dsts ...netip.Prefix) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, dst := range dsts {
+		if err := m.deleteLocked(routeKey{luid: m.luid, dst: dst, nextHop: unspecified(dst.Addr())}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set set the routes of adapter to dsts, the routes added by Add but not
+// in dsts are deleted, the routes added by Bypass are kept
+func (m *RouteManager) Set(dsts []netip.Prefix) error {
+	var keep = map[routeKey]bool{}
+	for _, dst := range dsts {
+		if err := validateRoute(dst); err != nil {
+			return err
+		}
+		keep[routeKey{luid: m.luid, dst: dst, nextHop: unspecified(dst.Addr())}] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.routes {
+		if key.luid == m.luid && !keep[key] {
+			if err := m.deleteLocked(key); err != nil {
+				return err
+			}
+		}
+	}
+	for key := range keep {
+		if err := m.addLocked(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OverrideDefault route all traffic to adapter by DefaultRoutes, usually
+// the tunnel endpoints should Bypass before.
+func (m *RouteManager) OverrideDefault(v4, v6 bool) error {
+	return m.Add(DefaultRoutes(v4, v6)...)
+}
+
+// Bypass keep endpoints on physical interface, add host route by the route
+// currently used except adapter's routes
+func (m *RouteManager) Bypass(endpoints ...netip.Addr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ep := range endpoints {
+		ep = ep.Unmap()
+		if !ep.IsValid() {
+			return errors.Errorf("invalid endpoint %s", ep)
+		}
+
+		table, err := routeTable(ep)
+		if err != nil {
+			return err
+		}
+		r, ok := lookupRoute(table, ep, uint64(m.luid))
+		if !ok {
+			return errors.Errorf("no route to endpoint %s", ep)
+		}
+		key := routeKey{luid: winipcfg.LUID(r.iface), dst: hostRoute(ep), nextHop: r.nextHop}
+		if err := m.addLocked(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Routes get the routes added by manager
+func (m *RouteManager) Routes() []*winipcfg.RouteData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var routes []*winipcfg.RouteData
+	for _, row := range m.routes {
+		routes = append(routes, &winipcfg.RouteData{
+			Destination: row.DestinationPrefix.Prefix(),
+			NextHop:     row.NextHop.Addr(),
+			Metric:      row.Metric,
+		})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Destination.String() < routes[j].Destination.String()
+	})
+	return routes
+}
+
+// Close delete all routes added by manager, and detach from adapter
+func (m *RouteManager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+
+	var err error
+	for key := range m.routes {
+		if e := m.deleteLocked(key); err == nil {
+			err = e
+		}
+	}
+	m.mu.Unlock()
+
+	m.a.mu.Lock()
+	m.a.routes = slices.DeleteFunc(m.a.routes, func(e *RouteManager) bool { return e == m })
+	m.a.mu.Unlock()
+
+	m.wg.Wait()
+	return err
+}
+
+func (m *RouteManager) addLocked(key routeKey) error {
+	if m.closed {
+		return errors.WithStack(ErrRouteManagerClosed{})
+	} else if _, ok := m.routes[key]; ok {
+		return nil
+	}
+
+	row := &winipcfg.MibIPforwardRow2{}
+	row.Init()
+	row.InterfaceLUID = key.luid
+	if err := row.DestinationPrefix.SetPrefix(key.dst); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := row.NextHop.SetAddr(key.nextHop); err != nil {
+		return errors.WithStack(err)
+	}
+	row.Metric = m.cfg.Metric
+	row.ValidLifetime = m.lifetime()
+	row.PreferredLifetime = row.ValidLifetime
+
+	if err := row.Create(); err != nil {
+		// exist route not owned by manager, don't track it
+		if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+	m.routes[key] = row
+	return nil
+}
+
+func (m *RouteManager) deleteLocked(key routeKey) error {
+	row, ok := m.routes[key]
+	if !ok {
+		return nil
+	}
+	delete(m.routes, key)
+
+	err := row.Delete()
+	if err == windows.ERROR_NOT_FOUND {
+		return nil // expired or removed with interface
+	}
+	return errors.WithStack(err)
+}
+
+// lifetime route lifetime in seconds
+func (m *RouteManager) lifetime() uint32 {
+	return uint32(max(m.cfg.Lease/time.Second, 1))
+}
+
+// refresh renew lifetime of added routes, the removed route is re-created
+func (m *RouteManager) refresh() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.cfg.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+
+		var errs []error
+		m.mu.Lock()
+		for key, row := range m.routes {
+			row.ValidLifetime = m.lifetime()
+			row.PreferredLifetime = row.ValidLifetime
+			err := row.Set()
+			if err == windows.ERROR_NOT_FOUND {
+				err = row.Create()
+			}
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "refresh route %s", key.dst))
+			}
+		}
+		m.mu.Unlock()
+
+		for _, err := range errs {
+			m.cfg.OnError(err)
+		}
+	}
+}
+
+// routeTable get system routes of addr's family
+func routeTable(addr netip.Addr) ([]route, error) {
+	family := winipcfg.AddressFamily(windows.AF_INET6)
+	if addr.Is4() {
+		family = windows.AF_INET
+	}
+	rows, err := winipcfg.GetIPForwardTable2(family)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var (
+		table   = make([]route, 0, len(rows))
+		metrics = map[winipcfg.LUID]uint32{}
+	)
+	for i := range rows {
+		row := &rows[i]
+		metric, ok := metrics[row.InterfaceLUID]
+		if !ok {
+			iface, err := row.InterfaceLUID.IPInterface(family)
+			if err != nil || !iface.Connected {
+				metrics[row.InterfaceLUID] = ^uint32(0)
+				continue
+			}
+			metric, metrics[row.InterfaceLUID] = iface.Metric, iface.Metric
+		} else if metric == ^uint32(0) {
+			continue
+		}
+
+		table = append(table, route{
+			dst:     row.DestinationPrefix.Prefix(),
+			nextHop: row.NextHop.Addr(),
+			iface:   uint64(row.InterfaceLUID),
+			metric:  row.Metric + metric,
+		})
+	}
+	return table, nil
+}
+
+func unspecified(addr netip.Addr) netip.Addr {
+	if addr.Is4() {
+		return netip.IPv4Unspecified()
+	}
+	return netip.IPv6Unspecified()
+}
@@ -0,0 +1,70 @@
+//go:build windows
+// +build windows
+
+package wintun
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RouteConfig(t *testing.T) {
+	var cfg = RouteConfig{Lease: time.Nanosecond}
+	cfg.init()
+	require.Equal(t, time.Second, cfg.Lease)
+
+	cfg = RouteConfig{}
+	cfg.init()
+	require.Equal(t, time.Minute, cfg.Lease)
+	require.NotNil(t, cfg.OnError)
+}
+
+func Test_RouteManager_Lease(t *testing.T) {
+	MustLoad(DLL)
+
+	ap, err := CreateAdapter("testroutemanagerlease")
+	require.NoError(t, err)
+	defer ap.Close()
+	luid, err := ap.GetAdapterLuid()
+	require.NoError(t, err)
+	err = luid.SetIPAddresses([]netip.Prefix{netip.MustParsePrefix("10.0.9.3/24")})
+	require.NoError(t, err)
+
+	m, err := ap.RouteManager(RouteConfig{Lease: time.Second * 2})
+	require.NoError(t, err)
+	var dst = netip.MustParsePrefix("10.9.8.0/24")
+	require.NoError(t, m.Add(dst))
+
+	// refreshed before expired
+	time.Sleep(time.Second * 4)
+	_, err = luid.Route(dst, netip.IPv4Unspecified())
+	require.NoError(t, err)
+
+	// stop refresh like process exit, the route removed by system
+	close(m.done)
+	m.wg.Wait()
+	require.Eventually(t, func() bool {
+		_, err := luid.Route(dst, netip.IPv4Unspecified())
+		return err != nil
+	}, time.Second*10, time.Millisecond*100)
+}
+
+func Test_RouteManager_Close(t *testing.T) {
+	MustLoad(DLL)
+
+	ap, err := CreateAdapter("testroutemanagerclose")
+	require.NoError(t, err)
+	defer ap.Close()
+
+	m, err := ap.RouteManager(RouteConfig{})
+	require.NoError(t, err)
+
+	// closed by user, detached from adapter
+	require.NoError(t, m.Close())
+	ap.mu.RLock()
+	require.Empty(t, ap.routes)
+	ap.mu.RUnlock()
+}
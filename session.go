@@ -0,0 +1,66 @@
+package wintun
+
+import "sync/atomic"
+
+// sessionRef reference count of session, every operation on session and
+// every received/allocated packet hold a reference, the session can be ended
+// only after all references released.
+type sessionRef struct {
+	// the owner hold one reference, released by shutdown
+	refs    atomic.Int64
+	closing atomic.Bool
+	drained chan struct{}
+}
+
+func newSessionRef() *sessionRef {
+	r := &sessionRef{drained: make(chan struct{})}
+	r.refs.Store(1)
+	return r
+}
+
+// acquire reference, return false if session is shutdown
+func (r *sessionRef) acquire() bool {
+	for !r.closing.Load() {
+		n := r.refs.Load()
+		if n <= 0 {
+			return false
+		} else if r.refs.CompareAndSwap(n, n+1) {
+			// shutdown after load closing, the references acquired later
+			// must not prevent drained
+			if r.closing.Load() {
+				r.release()
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sessionRef) release() {
+	if r.refs.Add(-1) == 0 {
+		close(r.drained)
+	}
+}
+
+// reopen cancel the shutdown, re-acquire the owner reference, return false if
+// already drained, then session must be ended.
+func (r *sessionRef) reopen() bool {
+	for {
+		n := r.refs.Load()
+		if n <= 0 {
+			return false
+		} else if r.refs.CompareAndSwap(n, n+1) {
+			r.closing.Store(false)
+			return true
+		}
+	}
+}
+
+// shutdown release the owner reference, later acquire will fail, return
+// channel closed when all references released. must be called only once.
+func (r *sessionRef) shutdown() <-chan struct{} {
+	r.closing.Store(true)
+	r.release()
+	return r.drained
+}
@@ -0,0 +1,79 @@
+package wintun
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SessionRef(t *testing.T) {
+	t.Run("shutdown", func(t *testing.T) {
+		r := newSessionRef()
+		require.True(t, r.acquire())
+
+		drained := r.shutdown()
+		select {
+		case <-drained:
+			t.Fatal("drained with outstanding reference")
+		default:
+		}
+		require.False(t, r.acquire())
+
+		r.release()
+		<-drained
+		require.False(t, r.acquire())
+	})
+
+	t.Run("reopen", func(t *testing.T) {
+		r := newSessionRef()
+		require.True(t, r.acquire())
+
+		drained := r.shutdown()
+		require.True(t, r.reopen())
+		require.True(t, r.acquire())
+		r.release()
+
+		// release the held reference, only the owner reference left
+		r.release()
+		select {
+		case <-drained:
+			t.Fatal("drained after reopen")
+		default:
+		}
+
+		<-r.shutdown()
+		require.False(t, r.reopen())
+		require.False(t, r.acquire())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		r := newSessionRef()
+
+		var (
+			wg     sync.WaitGroup
+			active atomic.Int64
+			ended  atomic.Bool
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for r.acquire() {
+					active.Add(1)
+					require.False(t, ended.Load())
+					active.Add(-1)
+					r.release()
+				}
+			}()
+		}
+
+		time.Sleep(time.Millisecond * 10)
+		<-r.shutdown()
+		ended.Store(true)
+		require.Zero(t, active.Load())
+		wg.Wait()
+	})
+}
@@ -0,0 +1,267 @@
+// Package socks5 socks5 client egress, relay the flows terminated by forward
+// package through socks5 server, support username/password auth and UDP
+// ASSOCIATE.
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/pkg/errors"
+)
+
+const version = 5
+
+// auth methods
+const (
+	methodNoAuth   = 0x00
+	methodPassword = 0x02
+	methodNone     = 0xff
+)
+
+// commands
+const (
+	cmdConnect      = 0x01
+	cmdUDPAssociate = 0x03
+)
+
+// address types
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+type ErrAuth struct{}
+
+func (ErrAuth) Error() string { return "socks5 authentication failed" }
+
+// ErrReply socks5 server reply failure code
+type ErrReply struct{ Code byte }
+
+func (e ErrReply) Error() string {
+	switch e.Code {
+	case 0x01:
+		return "socks5 general server failure"
+	case 0x02:
+		return "socks5 connection not allowed by ruleset"
+	case 0x03:
+		return "socks5 network unreachable"
+	case 0x04:
+		return "socks5 host unreachable"
+	case 0x05:
+		return "socks5 connection refused"
+	case 0x06:
+		return "socks5 ttl expired"
+	case 0x07:
+		return "socks5 command not supported"
+	case 0x08:
+		return "socks5 address type not supported"
+	default:
+		return fmt.Sprintf("socks5 unknown reply code %d", e.Code)
+	}
+}
+
+// Dialer dial socks5 server, *net.Dialer implement it
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Client socks5 client, it implement forward.Egress
+type Client struct {
+	// Server socks5 server address, host:port
+	Server string
+
+	// Username and Password for username/password authentication, empty
+	// Username means no authentication
+	Username, Password string
+
+	// Dialer default *net.Dialer
+	Dialer Dialer
+}
+
+var _ forward.Egress = (*Client)(nil)
+
+func (c *Client) dialer() Dialer {
+	if c.Dialer == nil {
+		return &net.Dialer{}
+	}
+	return c.Dialer
+}
+
+// DialTCP connect dst through socks5 server, src is ignored
+func (c *Client) DialTCP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	conn, _, err := c.request(ctx, cmdConnect, dst)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// DialUDP associate udp relay with socks5 server, the returned conn only
+// exchange datagrams with dst, src is ignored.
+func (c *Client) DialUDP(ctx context.Context, src, dst netip.AddrPort) (net.Conn, error) {
+	ctrl, relay, err := c.request(ctx, cmdUDPAssociate, netip.AddrPortFrom(netip.IPv4Unspecified(), 0))
+	if err != nil {
+		return nil, err
+	}
+	if relay.Addr().IsUnspecified() {
+		// relay on the server address
+		host, _, err := net.SplitHostPort(ctrl.RemoteAddr().String())
+		if err != nil {
+			ctrl.Close()
+			return nil, errors.WithStack(err)
+		}
+		addr, err := netip.ParseAddr(host)
+		if err != nil {
+			ctrl.Close()
+			return nil, errors.WithStack(err)
+		}
+		relay = netip.AddrPortFrom(addr, relay.Port())
+	}
+
+	raw, err := c.dialer().DialContext(ctx, "udp", relay.String())
+	if err != nil {
+		ctrl.Close()
+		return nil, errors.WithStack(err)
+	}
+	return newUDPConn(ctrl, raw, dst), nil
+}
+
+// request connect to server, authenticate and send request, return the
+// control connection and the bound address of reply
+func (c *Client) request(ctx context.Context, cmd byte, dst netip.AddrPort) (net.Conn, netip.AddrPort, error) {
+	conn, err := c.dialer().DialContext(ctx, "tcp", c.Server)
+	if err != nil {
+		return nil, netip.AddrPort{}, errors.WithStack(err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	bound, err := c.handshake(conn, cmd, dst)
+	if !stop() {
+		// ctx done while handshaking
+		err = errors.WithStack(ctx.Err())
+	}
+	if err != nil {
+		conn.Close()
+		return nil, netip.AddrPort{}, err
+	}
+	return conn, bound, nil
+}
+
+func (c *Client) handshake(conn net.Conn, cmd byte, dst netip.AddrPort) (netip.AddrPort, error) {
+	if err := c.auth(conn); err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	var req = []byte{version, cmd, 0}
+	req = appendAddr(req, dst)
+	if _, err := conn.Write(req); err != nil {
+		return netip.AddrPort{}, errors.WithStack(err)
+	}
+
+	var hdr [3]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return netip.AddrPort{}, errors.WithStack(err)
+	} else if hdr[0] != version {
+		return netip.AddrPort{}, errors.Errorf("invalid socks version %d", hdr[0])
+	} else if hdr[1] != 0 {
+		return netip.AddrPort{}, errors.WithStack(ErrReply{Code: hdr[1]})
+	}
+	return readAddr(conn)
+}
+
+func (c *Client) auth(conn net.Conn) error {
+	var methods = []byte{version, 1, methodNoAuth}
+	if c.Username != "" {
+		methods = []byte{version, 2, methodNoAuth, methodPassword}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return errors.WithStack(err)
+	} else if resp[0] != version {
+		return errors.Errorf("invalid socks version %d", resp[0])
+	}
+	switch resp[1] {
+	case methodNoAuth:
+		return nil
+	case methodPassword:
+		if c.Username == "" {
+			return errors.WithStack(ErrAuth{})
+		}
+	default:
+		return errors.WithStack(ErrAuth{})
+	}
+
+	if len(c.Username) > 255 || len(c.Password) > 255 {
+		return errors.Errorf("socks5 username or password too long")
+	}
+	var req = []byte{1, byte(len(c.Username))}
+	req = append(req, c.Username...)
+	req = append(req, byte(len(c.Password)))
+	req = append(req, c.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return errors.WithStack(err)
+	} else if resp[1] != 0 {
+		return errors.WithStack(ErrAuth{})
+	}
+	return nil
+}
+
+func appendAddr(b []byte, addr netip.AddrPort) []byte {
+	if a := addr.Addr().Unmap(); a.Is4() {
+		b = append(b, atypIPv4)
+		b = append(b, a.AsSlice()...)
+	} else {
+		b = append(b, atypIPv6)
+		b = append(b, a.AsSlice()...)
+	}
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+func readAddr(r io.Reader) (netip.AddrPort, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return netip.AddrPort{}, errors.WithStack(err)
+	}
+
+	var b []byte
+	switch atyp[0] {
+	case atypIPv4:
+		b = make([]byte, 4+2)
+	case atypIPv6:
+		b = make([]byte, 16+2)
+	case atypDomain:
+		// the domain bound address is not used, only consume it
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return netip.AddrPort{}, errors.WithStack(err)
+		}
+		b = make([]byte, int(n[0])+2)
+	default:
+		return netip.AddrPort{}, errors.WithStack(ErrReply{Code: 0x08})
+	}
+	if _, err := io.ReadFull(r, b); err != nil {
+		return netip.AddrPort{}, errors.WithStack(err)
+	}
+
+	port := binary.BigEndian.Uint16(b[len(b)-2:])
+	if atyp[0] == atypDomain {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), port), nil
+	}
+	addr, _ := netip.AddrFromSlice(b[:len(b)-2])
+	return netip.AddrPortFrom(addr, port), nil
+}
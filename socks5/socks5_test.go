@@ -0,0 +1,340 @@
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go/forward"
+	"github.com/lysShub/wintun-go/internal/stacktest"
+	"github.com/lysShub/wintun-go/socks5"
+	"github.com/stretchr/testify/require"
+)
+
+// server minimal in-process socks5 server, all CONNECT and udp datagrams are
+// redirected to the local echo servers, the requested destinations are recorded
+type server struct {
+	username, password string
+	tcp, udp           string
+
+	l net.Listener
+
+	mu  sync.Mutex
+	dst []netip.AddrPort
+}
+
+func newServer(t *testing.T, username, password string) *server {
+	var s = &server{username: username, password: password}
+	s.tcp, s.udp = echoServers(t)
+
+	var err error
+	s.l, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.l.Close() })
+
+	go func() {
+		for {
+			conn, err := s.l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *server) addr() string { return s.l.Addr().String() }
+
+func (s *server) record(dst netip.AddrPort) {
+	s.mu.Lock()
+	s.dst = append(s.dst, dst)
+	s.mu.Unlock()
+}
+
+func (s *server) recorded() []netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]netip.AddrPort{}, s.dst...)
+}
+
+func readAddr(r io.Reader) netip.AddrPort {
+	var atyp [1]byte
+	io.ReadFull(r, atyp[:])
+	var b []byte
+	if atyp[0] == 1 {
+		b = make([]byte, 6)
+	} else {
+		b = make([]byte, 18)
+	}
+	io.ReadFull(r, b)
+	addr, _ := netip.AddrFromSlice(b[:len(b)-2])
+	return netip.AddrPortFrom(addr, binary.BigEndian.Uint16(b[len(b)-2:]))
+}
+
+func appendAddr(b []byte, addr netip.AddrPort) []byte {
+	if addr.Addr().Is4() {
+		b = append(b, 1)
+	} else {
+		b = append(b, 4)
+	}
+	b = append(b, addr.Addr().AsSlice()...)
+	return binary.BigEndian.AppendUint16(b, addr.Port())
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var hdr = make([]byte, 2)
+	io.ReadFull(conn, hdr)
+	methods := make([]byte, hdr[1])
+	io.ReadFull(conn, methods)
+
+	method := byte(0x00)
+	if s.username != "" {
+		method = 0x02
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		conn.Write([]byte{5, 0xff})
+		return
+	}
+	conn.Write([]byte{5, method})
+
+	if method == 0x02 {
+		var n [1]byte
+		io.ReadFull(conn, hdr)
+		user := make([]byte, hdr[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, n[:])
+		pass := make([]byte, n[0])
+		io.ReadFull(conn, pass)
+		if string(user) != s.username || string(pass) != s.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	var req = make([]byte, 3)
+	io.ReadFull(conn, req)
+	dst := readAddr(conn)
+
+	switch req[1] {
+	case 1:
+		if dst.Port() == 81 {
+			conn.Write(appendAddr([]byte{5, 5, 0}, netip.AddrPortFrom(netip.IPv4Unspecified(), 0)))
+			return
+		}
+		s.record(dst)
+		target, err := net.Dial("tcp", s.tcp)
+		if err != nil {
+			return
+		}
+		defer target.Close()
+		conn.Write(appendAddr([]byte{5, 0, 0}, netip.MustParseAddrPort(target.LocalAddr().String())))
+
+		go func() {
+			io.Copy(target, conn)
+			target.(*net.TCPConn).CloseWrite()
+		}()
+		io.Copy(conn, target)
+	case 3:
+		relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		// bound to unspecified address, client should use server address
+		port := netip.MustParseAddrPort(relay.LocalAddr().String()).Port()
+		conn.Write(appendAddr([]byte{5, 0, 0}, netip.AddrPortFrom(netip.IPv4Unspecified(), port)))
+
+		go s.relay(relay)
+		io.Copy(io.Discard, conn)
+	default:
+		conn.Write(appendAddr([]byte{5, 7, 0}, netip.AddrPortFrom(netip.IPv4Unspecified(), 0)))
+	}
+}
+
+func (s *server) relay(relay net.PacketConn) {
+	target, err := net.Dial("udp", s.udp)
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	var b = make([]byte, 0xffff)
+	for {
+		n, client, err := relay.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		r := bytes.NewReader(b[3:n])
+		dst := readAddr(r)
+		s.record(dst)
+
+		target.Write(b[n-r.Len() : n])
+		m, err := target.Read(b)
+		if err != nil {
+			return
+		}
+		relay.WriteTo(append(appendAddr([]byte{0, 0, 0}, dst), b[:m]...), client)
+	}
+}
+
+func echoServers(t *testing.T) (tcp, udp string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+		}
+	}()
+
+	u, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { u.Close() })
+	go func() {
+		var b = make([]byte, 0xffff)
+		for {
+			n, addr, err := u.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			u.WriteTo(b[:n], addr)
+		}
+	}()
+	return l.Addr().String(), u.LocalAddr().String()
+}
+
+var (
+	src  = netip.MustParseAddrPort("10.6.7.8:1234")
+	dst4 = netip.MustParseAddrPort("203.0.113.1:80")
+	dst6 = netip.MustParseAddrPort("[2001:db8::1]:53")
+)
+
+func Test_Client(t *testing.T) {
+	s := newServer(t, "user", "pass")
+	c := &socks5.Client{Server: s.addr(), Username: "user", Password: "pass"}
+	ctx := context.Background()
+
+	t.Run("connect", func(t *testing.T) {
+		conn, err := c.DialTCP(ctx, src, dst4)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		var b = make([]byte, 5)
+		_, err = io.ReadFull(conn, b)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b))
+	})
+
+	t.Run("udp-associate", func(t *testing.T) {
+		conn, err := c.DialUDP(ctx, src, dst6)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		var b = make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b[:n]))
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		_, err := c.DialTCP(ctx, src, netip.AddrPortFrom(dst4.Addr(), 81))
+		require.ErrorIs(t, err, socks5.ErrReply{Code: 5})
+	})
+
+	t.Run("auth-fail", func(t *testing.T) {
+		c := &socks5.Client{Server: s.addr(), Username: "user", Password: "wrong"}
+		_, err := c.DialTCP(ctx, src, dst4)
+		require.ErrorIs(t, err, socks5.ErrAuth{})
+
+		c = &socks5.Client{Server: s.addr()}
+		_, err = c.DialTCP(ctx, src, dst4)
+		require.ErrorIs(t, err, socks5.ErrAuth{})
+	})
+
+	require.Equal(t, []netip.AddrPort{dst4, dst6}, s.recorded())
+}
+
+func Test_Client_Cancel(t *testing.T) {
+	// server accept but never response
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	c := &socks5.Client{Server: l.Addr().String()}
+	_, err = c.DialTCP(ctx, src, dst4)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func Test_Forward(t *testing.T) {
+	s := newServer(t, "", "")
+
+	host, err := stacktest.New(1500, src.Addr())
+	require.NoError(t, err)
+	defer host.Close()
+
+	f, err := forward.New(host, &socks5.Client{Server: s.addr()}, forward.Config{})
+	require.NoError(t, err)
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Serve(ctx)
+
+	t.Run("tcp", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+		defer cancel()
+
+		conn, err := host.DialTCP(ctx, dst4)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("hello"))
+		require.NoError(t, err)
+		var b = make([]byte, 5)
+		_, err = io.ReadFull(conn, b)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(b))
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := host.DialUDP(netip.MustParseAddrPort("203.0.113.2:53"))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("query"))
+		require.NoError(t, err)
+		var b = make([]byte, 64)
+		conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+		n, err := conn.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "query", string(b[:n]))
+	})
+
+	require.Equal(t,
+		[]netip.AddrPort{dst4, netip.MustParseAddrPort("203.0.113.2:53")},
+		s.recorded(),
+	)
+}
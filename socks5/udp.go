@@ -0,0 +1,86 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// udpConn datagram conn through socks5 udp relay, the udp association is
+// alive as long as the control connection
+type udpConn struct {
+	net.Conn // udp socket connected to relay
+	ctrl     net.Conn
+	dst      netip.AddrPort
+	hdr      []byte
+
+	rmu, wmu   sync.Mutex
+	rbuf, wbuf []byte
+
+	closeOnce sync.Once
+}
+
+func newUDPConn(ctrl, raw net.Conn, dst netip.AddrPort) *udpConn {
+	var c = &udpConn{
+		Conn: raw,
+		ctrl: ctrl,
+		dst:  dst,
+		hdr:  appendAddr([]byte{0, 0, 0}, dst),
+		rbuf: make([]byte, 0xffff),
+	}
+	go func() {
+		// association terminated when control connection closed
+		io.Copy(io.Discard, ctrl)
+		c.Close()
+	}()
+	return c
+}
+
+func (c *udpConn) Read(b []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	buf := c.rbuf
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		// RSV(2) FRAG(1) ADDR, the fragmented datagram is dropped
+		if n < 3 || buf[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		src, err := readAddr(r)
+		if err != nil || src.Addr().Unmap() != c.dst.Addr().Unmap() || src.Port() != c.dst.Port() {
+			continue
+		}
+		return copy(b, buf[n-r.Len():n]), nil
+	}
+}
+
+func (c *udpConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.wbuf = append(append(c.wbuf[:0], c.hdr...), b...)
+	if _, err := c.Conn.Write(c.wbuf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *udpConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.Conn.Close()
+		if e := c.ctrl.Close(); err == nil {
+			err = e
+		}
+	})
+	return errors.WithStack(err)
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package split
+
+import (
+	"net/netip"
+
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// RouteData get Routes as on-link routes of adapter, can be set by
+// winipcfg.LUID.SetRoutes
+func (e *Engine) RouteData(metric uint32) []*winipcfg.RouteData {
+	var routes []*winipcfg.RouteData
+	for _, p := range e.Routes() {
+		nextHop := netip.IPv6Unspecified()
+		if p.Addr().Is4() {
+			nextHop = netip.IPv4Unspecified()
+		}
+		routes = append(routes, &winipcfg.RouteData{Destination: p, NextHop: nextHop, Metric: metric})
+	}
+	return routes
+}
@@ -0,0 +1,113 @@
+package split
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Action decision of packet
+type Action uint8
+
+const (
+	// Tunnel keep packet in tunnel
+	Tunnel Action = iota + 1
+	// Bypass packet bypass tunnel, go through physical interface
+	Bypass
+)
+
+func (a Action) String() string {
+	switch a {
+	case Tunnel:
+		return "tunnel"
+	case Bypass:
+		return "bypass"
+	default:
+		return "unknown"
+	}
+}
+
+// Rule split rule, match destination by Prefix or Domain, and by Proto and
+// port range if set. the rule with higher Priority wins, if equal, the more
+// specific prefix wins, then the former rule.
+type Rule struct {
+	Priority int
+	Action   Action
+
+	// Prefix destination prefix, zero value and empty Domain means any
+	// destination
+	Prefix netip.Prefix
+
+	// Domain match the domain and its subdomains, the destination addresses
+	// are learned from dns responses, exclusive with Prefix
+	Domain string
+
+	// Proto ip protocol, such as packet.TCP, zero means any
+	Proto uint8
+
+	// PortMin and PortMax destination port range, zero means any
+	PortMin, PortMax uint16
+}
+
+type ErrInvalidRule struct {
+	Rule   Rule
+	Reason string
+}
+
+func (e ErrInvalidRule) Error() string { return "invalid split rule: " + e.Reason }
+
+func (r *Rule) init() error {
+	invalid := func(reason string) error {
+		return errors.WithStack(ErrInvalidRule{Rule: *r, Reason: reason})
+	}
+	if r.Action != Tunnel && r.Action != Bypass {
+		return invalid("unknown action")
+	}
+	if r.Domain != "" {
+		if r.Prefix.IsValid() {
+			return invalid("both prefix and domain")
+		}
+		r.Domain = canonical(r.Domain)
+		if r.Domain == "" {
+			return invalid("empty domain")
+		}
+	} else if r.Prefix.IsValid() {
+		r.Prefix = netip.PrefixFrom(r.Prefix.Addr().Unmap(), r.Prefix.Bits())
+		if r.Prefix.Addr().Is4() && r.Prefix.Bits() > 32 {
+			return invalid("invalid prefix")
+		}
+		r.Prefix = r.Prefix.Masked()
+	}
+	if r.PortMax == 0 {
+		r.PortMax = r.PortMin
+	}
+	if r.PortMin > r.PortMax {
+		return invalid("invalid port range")
+	}
+	return nil
+}
+
+// anyPort rule match all packets to its destinations
+func (r *Rule) anyPort() bool {
+	return r.Proto == 0 && r.PortMin == 0
+}
+
+func (r *Rule) matchPort(proto uint8, port uint16) bool {
+	if r.Proto != 0 && r.Proto != proto {
+		return false
+	}
+	return r.PortMin == 0 || (r.PortMin <= port && port <= r.PortMax)
+}
+
+// matchDomain name is the domain or its subdomain
+func (r *Rule) matchDomain(name string) bool {
+	if r.Domain == "" {
+		return false
+	}
+	return name == r.Domain || strings.HasSuffix(name, "."+r.Domain)
+}
+
+func canonical(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
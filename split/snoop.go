@@ -0,0 +1,193 @@
+package split
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/lysShub/wintun-go/packet"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const dnsPort = 53
+
+// Snoop learn destination addresses of domain rules from ip if it is udp dns
+// response, others are ignored.
+func (e *Engine) Snoop(ip []byte) {
+	if len(e.domains) == 0 {
+		return
+	}
+	info, err := packet.Parse(ip)
+	if err != nil || info.Fragmented || info.Proto != packet.UDP || info.SrcPort != dnsPort {
+		return
+	}
+	e.Learn(info.Payload(ip))
+}
+
+// Learn learn destination addresses of domain rules from dns response message
+func (e *Engine) Learn(msg []byte) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil || !hdr.Response || hdr.RCode != dnsmessage.RCodeSuccess {
+		return
+	}
+	q, err := p.Question()
+	if err != nil {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+
+	var (
+		name  = canonical(q.Name.String())
+		names = []string{name} // question name and its cname chain
+		rules []int
+	)
+	for _, i := range e.domains {
+		if e.rules[i].matchDomain(name) {
+			rules = append(rules, i)
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	type answer struct {
+		addr netip.Addr
+		ttl  time.Duration
+	}
+	var answers []answer
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			break
+		} else if !slices.Contains(names, canonical(h.Name.String())) {
+			if p.SkipAnswer() != nil {
+				break
+			}
+			continue
+		}
+
+		ttl := time.Duration(h.TTL) * time.Second
+		switch h.Type {
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return
+			}
+			names = append(names, canonical(r.CNAME.String()))
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return
+			}
+			answers = append(answers, answer{netip.AddrFrom4(r.A), ttl})
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return
+			}
+			answers = append(answers, answer{netip.AddrFrom16(r.AAAA).Unmap(), ttl})
+		default:
+			if p.SkipAnswer() != nil {
+				return
+			}
+		}
+	}
+	if len(answers) == 0 {
+		return
+	}
+
+	var (
+		now     = time.Now()
+		changed bool
+	)
+	e.mu.Lock()
+	for _, a := range answers {
+		expire := now.Add(max(a.ttl, e.cfg.MinTTL))
+
+		l := e.learned[a.addr]
+		if l == nil || !now.Before(l.expire) {
+			l = &learned{}
+			e.learned[a.addr] = l
+		}
+		for _, r := range rules {
+			if !slices.Contains(l.rules, r) {
+				l.rules = append(l.rules, r)
+				changed = true
+			}
+		}
+		l.expire = maxTime(l.expire, expire)
+		e.scheduleLocked(l.expire, now)
+	}
+	e.mu.Unlock()
+
+	if changed && e.cfg.OnChange != nil {
+		e.cfg.OnChange()
+	}
+}
+
+// scheduleLocked arm the timer if at is before the next expiry
+func (e *Engine) scheduleLocked(at, now time.Time) {
+	if e.closed {
+		return
+	} else if e.timer != nil {
+		if !at.Before(e.next) {
+			return
+		}
+		e.timer.Stop()
+	}
+	e.next = at
+	e.timer = time.AfterFunc(at.Sub(now), e.expire)
+}
+
+// expire remove expired learned addresses, and arm the timer at next expiry
+func (e *Engine) expire() {
+	var (
+		now     = time.Now()
+		next    time.Time
+		changed bool
+	)
+	e.mu.Lock()
+	if e.closed || now.Before(e.next) {
+		e.mu.Unlock()
+		return // stopped or rescheduled
+	}
+	for addr, l := range e.learned {
+		if !now.Before(l.expire) {
+			delete(e.learned, addr)
+			changed = true
+		} else if next.IsZero() || l.expire.Before(next) {
+			next = l.expire
+		}
+	}
+	e.timer = nil
+	if !next.IsZero() {
+		e.scheduleLocked(next, now)
+	}
+	e.mu.Unlock()
+
+	if changed && e.cfg.OnChange != nil {
+		e.cfg.OnChange()
+	}
+}
+
+// Close stop the expiry timer of learned addresses
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	return nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,274 @@
+// Package split policy-based split tunneling, decide per packet whether keep
+// it in tunnel or bypass, by destination prefix, domain, protocol and port
+// rules, and emit the route set the adapter should carry.
+package split
+
+import (
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
+)
+
+type Config struct {
+	Rules []Rule
+
+	// Default action if no rule matched, default Tunnel
+	Default Action
+
+	// MinTTL minimum lifetime of addresses learned from dns responses,
+	// default 10min
+	MinTTL time.Duration
+
+	// OnChange called when learned addresses changed, include learned by
+	// Learn/Snoop and expired, that means Routes may be changed, it is called
+	// without lock held
+	OnChange func()
+}
+
+func (c *Config) init() error {
+	if c.Default == 0 {
+		c.Default = Tunnel
+	}
+	if c.MinTTL <= 0 {
+		c.MinTTL = time.Minute * 10
+	}
+	c.Rules = slices.Clone(c.Rules)
+	for i := range c.Rules {
+		if err := c.Rules[i].init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Engine split tunneling rule engine, it is safe for concurrent use
+type Engine struct {
+	cfg     Config
+	rules   []Rule
+	v4, v6  *trie
+	domains []int // index of domain rules
+
+	mu      sync.RWMutex
+	learned map[netip.Addr]*learned
+	// timer remove expired learned addresses at next expiry
+	timer  *time.Timer
+	next   time.Time
+	closed bool
+}
+
+// learned the destination address learned from dns response
+type learned struct {
+	rules  []int
+	expire time.Time
+}
+
+func New(cfg Config) (*Engine, error) {
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+
+	var e = &Engine{
+		cfg:     cfg,
+		rules:   cfg.Rules,
+		learned: map[netip.Addr]*learned{},
+	}
+	e.v4, e.v6 = e.build()
+	for i, r := range e.rules {
+		if r.Domain != "" {
+			e.domains = append(e.domains, i)
+		}
+	}
+	return e, nil
+}
+
+// build build tries of prefix rules
+func (e *Engine) build() (v4, v6 *trie) {
+	v4, v6 = newTrie(32), newTrie(128)
+	for i, r := range e.rules {
+		switch {
+		case r.Domain != "":
+		case !r.Prefix.IsValid():
+			v4.insert(netip.PrefixFrom(netip.IPv4Unspecified(), 0), i)
+			v6.insert(netip.PrefixFrom(netip.IPv6Unspecified(), 0), i)
+		case r.Prefix.Addr().Is4():
+			v4.insert(r.Prefix, i)
+		default:
+			v6.insert(r.Prefix, i)
+		}
+	}
+	return v4, v6
+}
+
+// candidate matched rule, bits is the prefix length it matched by
+type candidate struct {
+	rule, bits int
+}
+
+// better a is prior to b, the negative rule means default action
+func (e *Engine) better(a, b candidate) bool {
+	if b.rule < 0 {
+		return true
+	} else if a.rule < 0 {
+		return false
+	}
+	if pa, pb := e.rules[a.rule].Priority, e.rules[b.rule].Priority; pa != pb {
+		return pa > pb
+	}
+	if a.bits != b.bits {
+		return a.bits > b.bits
+	}
+	return a.rule < b.rule
+}
+
+func (e *Engine) action(c candidate) Action {
+	if c.rule < 0 {
+		return e.cfg.Default
+	}
+	return e.rules[c.rule].Action
+}
+
+// Decide decide the packet to dst with ip protocol proto
+func (e *Engine) Decide(dst netip.AddrPort, proto uint8) Action {
+	var (
+		addr = dst.Addr().Unmap()
+		best = candidate{rule: -1}
+	)
+	consider := func(rule, bits int) {
+		c := candidate{rule: rule, bits: bits}
+		if e.rules[rule].matchPort(proto, dst.Port()) && e.better(c, best) {
+			best = c
+		}
+	}
+
+	if addr.Is4() {
+		e.v4.walk(addr, consider)
+	} else {
+		e.v6.walk(addr, consider)
+	}
+
+	e.mu.RLock()
+	if l := e.learned[addr]; l != nil && time.Now().Before(l.expire) {
+		for _, r := range l.rules {
+			consider(r, addr.BitLen())
+		}
+	}
+	e.mu.RUnlock()
+
+	return e.action(best)
+}
+
+// DecidePacket decide ip packet, the invalid packet use default action
+func (e *Engine) DecidePacket(ip []byte) Action {
+	info, err := packet.Parse(ip)
+	if err != nil {
+		return e.cfg.Default
+	}
+	return e.Decide(netip.AddrPortFrom(info.Dst, info.DstPort), info.Proto)
+}
+
+// Handler snoop dns responses in packets, and pass them to tunnel or bypass
+// by decision, the handlers can be nil.
+func (e *Engine) Handler(tunnel, bypass wintun.Handler) wintun.Handler {
+	return func(dev wintun.Device, ip []byte) {
+		e.Snoop(ip)
+
+		if e.DecidePacket(ip) == Tunnel {
+			if tunnel != nil {
+				tunnel(dev, ip)
+			}
+		} else if bypass != nil {
+			bypass(dev, ip)
+		}
+	}
+}
+
+// Device wrap dev, snoop dns responses in packets sent to adapter
+func (e *Engine) Device(dev wintun.Device) wintun.Device {
+	return &device{Device: dev, e: e}
+}
+
+type device struct {
+	wintun.Device
+	e *Engine
+}
+
+func (d *device) Send(ip []byte) error {
+	d.e.Snoop(ip)
+	return d.Device.Send(ip)
+}
+
+// Routes get the destination prefixes the adapter should carry, they cover
+// all destinations that may be decided Tunnel. for the port specific rules,
+// the prefix is routed to adapter, and the packet need be decided again.
+func (e *Engine) Routes() []netip.Prefix {
+	v4, v6 := e.v4, e.v6
+
+	e.mu.RLock()
+	if len(e.learned) > 0 {
+		now := time.Now()
+		v4, v6 = e.build()
+		for addr, l := range e.learned {
+			if !now.Before(l.expire) {
+				continue
+			}
+			for _, r := range l.rules {
+				if addr.Is4() {
+					v4.insert(netip.PrefixFrom(addr, 32), r)
+				} else {
+					v6.insert(netip.PrefixFrom(addr, 128), r)
+				}
+			}
+		}
+	}
+	e.mu.RUnlock()
+
+	routes := e.routes(&v4.root, netip.PrefixFrom(netip.IPv4Unspecified(), 0), nil)
+	return append(routes, e.routes(&v6.root, netip.PrefixFrom(netip.IPv6Unspecified(), 0), nil)...)
+}
+
+// routes get tunnel prefixes under prefix p, n is the trie node of p, nil
+// means no more specific rules, cands are the rules of p's ancestors
+func (e *Engine) routes(n *node, p netip.Prefix, cands []candidate) []netip.Prefix {
+	if n != nil {
+		cands = cands[:len(cands):len(cands)]
+		for _, r := range n.rules {
+			cands = append(cands, candidate{rule: r, bits: p.Bits()})
+		}
+	}
+	if n == nil || (n.child[0] == nil && n.child[1] == nil) {
+		if e.mayTunnel(cands) {
+			return []netip.Prefix{p}
+		}
+		return nil
+	}
+
+	lo, hi := halves(p)
+	l, h := e.routes(n.child[0], lo, cands), e.routes(n.child[1], hi, cands)
+	if len(l) == 1 && l[0] == lo && len(h) == 1 && h[0] == hi {
+		return []netip.Prefix{p} // aggregate
+	}
+	return append(l, h...)
+}
+
+// mayTunnel some packets to destination matched cands may be decided Tunnel
+func (e *Engine) mayTunnel(cands []candidate) bool {
+	var best = candidate{rule: -1}
+	for _, c := range cands {
+		if e.rules[c.rule].anyPort() && e.better(c, best) {
+			best = c
+		}
+	}
+	if e.action(best) == Tunnel {
+		return true
+	}
+	for _, c := range cands {
+		if e.rules[c.rule].Action == Tunnel && e.better(c, best) {
+			return true
+		}
+	}
+	return false
+}
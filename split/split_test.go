@@ -0,0 +1,209 @@
+package split
+
+import (
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func prefixes(ss ...string) []netip.Prefix {
+	var ps []netip.Prefix
+	for _, s := range ss {
+		ps = append(ps, netip.MustParsePrefix(s))
+	}
+	return ps
+}
+
+func Test_Decide(t *testing.T) {
+	e, err := New(Config{
+		Default: Bypass,
+		Rules: []Rule{
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+			{Action: Bypass, Prefix: netip.MustParsePrefix("10.1.0.0/16")},
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("10.1.2.0/24"), Proto: packet.TCP, PortMin: 443},
+			{Action: Bypass, Prefix: netip.MustParsePrefix("10.2.0.0/16"), Priority: -1},
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("fd00::/8"), PortMin: 1000, PortMax: 2000},
+			{Action: Bypass, Priority: 10, Proto: packet.UDP, PortMin: 53},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		dst    string
+		proto  uint8
+		expect Action
+	}{
+		{"10.9.9.9:80", packet.TCP, Tunnel},
+		{"10.1.9.9:80", packet.TCP, Bypass},  // longest prefix
+		{"10.1.2.3:443", packet.TCP, Tunnel}, // port rule
+		{"10.1.2.3:443", packet.UDP, Bypass},
+		{"10.2.0.1:80", packet.TCP, Tunnel}, // lower priority
+		{"10.9.9.9:53", packet.UDP, Bypass}, // higher priority
+		{"192.168.0.1:80", packet.TCP, Bypass},
+		{"[fd00::1]:1500", packet.UDP, Tunnel},
+		{"[fd00::1]:2001", packet.UDP, Bypass},
+		{"[::ffff:10.0.0.1]:80", packet.TCP, Tunnel},
+	} {
+		require.Equal(t, c.expect, e.Decide(netip.MustParseAddrPort(c.dst), c.proto), c.dst)
+	}
+}
+
+func Test_Invalid(t *testing.T) {
+	for _, r := range []Rule{
+		{},
+		{Action: Tunnel, Prefix: netip.MustParsePrefix("10.0.0.0/8"), Domain: "example.com"},
+		{Action: Tunnel, Domain: "."},
+		{Action: Tunnel, PortMin: 2, PortMax: 1},
+	} {
+		_, err := New(Config{Rules: []Rule{r}})
+		require.ErrorAs(t, err, &ErrInvalidRule{})
+	}
+}
+
+func Test_Routes(t *testing.T) {
+	t.Run("default tunnel", func(t *testing.T) {
+		e, err := New(Config{Rules: []Rule{
+			{Action: Bypass, Prefix: netip.MustParsePrefix("128.0.0.0/1")},
+			{Action: Bypass, Prefix: netip.MustParsePrefix("64.0.0.0/2")},
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("64.0.0.0/3"), PortMin: 80},
+			{Action: Bypass, Prefix: netip.MustParsePrefix("::/0")},
+		}})
+		require.NoError(t, err)
+		require.Equal(t, prefixes("0.0.0.0/2", "64.0.0.0/3"), e.Routes())
+	})
+
+	t.Run("default bypass", func(t *testing.T) {
+		e, err := New(Config{Default: Bypass, Rules: []Rule{
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+			{Action: Bypass, Prefix: netip.MustParsePrefix("10.0.0.0/9")},
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("10.0.0.0/24"), Priority: 1},
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("fd00::/8")},
+		}})
+		require.NoError(t, err)
+		require.Equal(t, prefixes("10.0.0.0/24", "10.128.0.0/9", "fd00::/8"), e.Routes())
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		e, err := New(Config{Rules: []Rule{
+			{Action: Tunnel, Prefix: netip.MustParsePrefix("10.0.0.0/8")},
+		}})
+		require.NoError(t, err)
+		require.Equal(t, prefixes("0.0.0.0/0", "::/0"), e.Routes())
+	})
+}
+
+func response(t *testing.T, name string, cname string, addrs ...string) []byte {
+	return responseTTL(t, 60, name, cname, addrs...)
+}
+
+func responseTTL(t *testing.T, ttl uint32, name string, cname string, addrs ...string) []byte {
+	var (
+		b = dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true})
+		n = dnsmessage.MustNewName(name)
+	)
+	b.EnableCompression()
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{Name: n, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}))
+	require.NoError(t, b.StartAnswers())
+	if cname != "" {
+		c := dnsmessage.MustNewName(cname)
+		require.NoError(t, b.CNAMEResource(dnsmessage.ResourceHeader{Name: n, Class: dnsmessage.ClassINET, TTL: ttl}, dnsmessage.CNAMEResource{CNAME: c}))
+		n = c
+	}
+	for _, s := range addrs {
+		hdr := dnsmessage.ResourceHeader{Name: n, Class: dnsmessage.ClassINET, TTL: ttl}
+		if addr := netip.MustParseAddr(s); addr.Is4() {
+			require.NoError(t, b.AResource(hdr, dnsmessage.AResource{A: addr.As4()}))
+		} else {
+			require.NoError(t, b.AAAAResource(hdr, dnsmessage.AAAAResource{AAAA: addr.As16()}))
+		}
+	}
+	msg, err := b.Finish()
+	require.NoError(t, err)
+	return msg
+}
+
+func Test_Snoop(t *testing.T) {
+	var changed int
+	e, err := New(Config{
+		Default:  Bypass,
+		Rules:    []Rule{{Action: Tunnel, Domain: "Example.com."}},
+		OnChange: func() { changed++ },
+	})
+	require.NoError(t, err)
+
+	msg := response(t, "www.example.com.", "cdn.example.net.", "1.2.3.4", "2001:db8::1")
+	var (
+		h   = packet.IP{Src: netip.MustParseAddr("8.8.8.8"), Dst: netip.MustParseAddr("10.0.0.1")}
+		udp = &packet.UDPSegment{SrcPort: 53, DstPort: 12345, Payload: msg}
+		ip  = make([]byte, packet.IPv4MinSize+packet.UDPSize+len(msg))
+	)
+	_, err = packet.Build(ip, h, udp)
+	require.NoError(t, err)
+
+	require.Equal(t, Bypass, e.Decide(netip.MustParseAddrPort("1.2.3.4:443"), packet.TCP))
+	e.Snoop(ip)
+	require.Equal(t, 1, changed)
+	require.Equal(t, Tunnel, e.Decide(netip.MustParseAddrPort("1.2.3.4:443"), packet.TCP))
+	require.Equal(t, Tunnel, e.Decide(netip.MustParseAddrPort("[2001:db8::1]:443"), packet.TCP))
+	require.Equal(t, prefixes("1.2.3.4/32", "2001:db8::1/128"), e.Routes())
+
+	// same answers not change routes
+	e.Learn(msg)
+	require.Equal(t, 1, changed)
+
+	// not matched domain
+	e.Learn(response(t, "example.org.", "", "5.6.7.8"))
+	require.Equal(t, Bypass, e.Decide(netip.MustParseAddrPort("5.6.7.8:443"), packet.TCP))
+	require.Equal(t, 1, changed)
+}
+
+func Test_Expire(t *testing.T) {
+	e, err := New(Config{
+		Default: Bypass,
+		Rules:   []Rule{{Action: Tunnel, Domain: "example.com"}},
+		MinTTL:  time.Millisecond * 50,
+	})
+	require.NoError(t, err)
+
+	e.Learn(response(t, "example.com.", "", "1.2.3.4"))
+	e.mu.Lock()
+	e.learned[netip.MustParseAddr("1.2.3.4")].expire = time.Now()
+	e.mu.Unlock()
+
+	require.Equal(t, Bypass, e.Decide(netip.MustParseAddrPort("1.2.3.4:443"), packet.TCP))
+	require.Empty(t, e.Routes())
+}
+
+func Test_Expire_OnChange(t *testing.T) {
+	var changed atomic.Int32
+	e, err := New(Config{
+		Default:  Bypass,
+		Rules:    []Rule{{Action: Tunnel, Domain: "example.com"}},
+		MinTTL:   time.Millisecond * 50,
+		OnChange: func() { changed.Add(1) },
+	})
+	require.NoError(t, err)
+	defer e.Close()
+
+	e.Learn(responseTTL(t, 0, "example.com.", "", "1.2.3.4"))
+	e.Learn(responseTTL(t, 1, "example.com.", "", "5.6.7.8"))
+	require.Equal(t, int32(2), changed.Load())
+	require.Equal(t, prefixes("1.2.3.4/32", "5.6.7.8/32"), e.Routes())
+
+	// expired without Learn called
+	require.Eventually(t, func() bool { return changed.Load() == 3 }, time.Second, time.Millisecond*5)
+	require.Equal(t, prefixes("5.6.7.8/32"), e.Routes())
+	require.Eventually(t, func() bool { return changed.Load() == 4 }, time.Second*3, time.Millisecond*10)
+	require.Empty(t, e.Routes())
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	require.Empty(t, e.learned)
+	require.Nil(t, e.timer)
+}
@@ -0,0 +1,63 @@
+package split
+
+import "net/netip"
+
+// trie binary trie of one address family, used to longest-prefix-match
+type trie struct {
+	root node
+	bits int
+}
+
+type node struct {
+	child [2]*node
+	rules []int // index of rules, the prefix length is the node depth
+}
+
+func newTrie(bits int) *trie { return &trie{bits: bits} }
+
+func (t *trie) insert(p netip.Prefix, rule int) {
+	var (
+		n    = &t.root
+		addr = p.Addr().AsSlice()
+	)
+	for i := 0; i < p.Bits(); i++ {
+		b := bit(addr, i)
+		if n.child[b] == nil {
+			n.child[b] = &node{}
+		}
+		n = n.child[b]
+	}
+	n.rules = append(n.rules, rule)
+}
+
+// walk call fn with the rules of every prefix contains addr, from shortest
+// to longest
+func (t *trie) walk(addr netip.Addr, fn func(rule, bits int)) {
+	var (
+		n = &t.root
+		a = addr.AsSlice()
+	)
+	for i := 0; n != nil; i++ {
+		for _, r := range n.rules {
+			fn(r, i)
+		}
+		if i == t.bits {
+			break
+		}
+		n = n.child[bit(a, i)]
+	}
+}
+
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
+
+// halves split prefix p into two halves
+func halves(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	a := p.Addr().AsSlice()
+	lo := netip.PrefixFrom(p.Addr(), p.Bits()+1)
+
+	a[p.Bits()/8] |= 1 << (7 - p.Bits()%8)
+	addr, _ := netip.AddrFromSlice(a)
+	return lo, netip.PrefixFrom(addr, p.Bits()+1)
+}
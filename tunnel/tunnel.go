@@ -0,0 +1,385 @@
+// Package tunnel simple UDP encapsulation tunnel, connect two adapters
+// through UDP, it runs over any wintun.Device.
+//
+// the datagram format:
+//
+//	0               1               2               3
+//	+-------+-------+---------------+-------------------------------+
+//	|version| type  |     flags     |           reserved            |
+//	+-------+-------+---------------+-------------------------------+
+//	|                 sequence number (if flagSeq)                  |
+//	+---------------------------------------------------------------+
+//	|                       ip packet (data)                        |
+//	+---------------------------------------------------------------+
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/pkg/errors"
+)
+
+const version = 1
+
+// datagram types
+const (
+	typeData      = 1
+	typeKeepalive = 2
+)
+
+// flags
+const flagSeq = 1 << 0
+
+const (
+	hdrSize = 4
+	seqSize = 4
+)
+
+// minimum keepalive interval
+const minKeepalive = time.Millisecond * 10
+
+type ErrInvalidDatagram struct{}
+
+func (ErrInvalidDatagram) Error() string { return "invalid tunnel datagram" }
+
+// Overhead encapsulation overhead of tunnel to peer, include outer ip and udp
+// header
+func Overhead(peer netip.Addr, seq bool) int {
+	n := packet.UDPSize + hdrSize
+	if seq {
+		n += seqSize
+	}
+	if peer.Unmap().Is4() {
+		return n + packet.IPv4MinSize
+	}
+	return n + packet.IPv6Size
+}
+
+type Config struct {
+	// Peer remote tunnel address, if invalid, the peer is learned if Learn,
+	// and the packets from Device are dropped before that.
+	Peer netip.AddrPort
+
+	// Learn learn Peer from the first received valid datagram when Peer is
+	// invalid. it trusts any sender, whoever reach the tunnel first becomes
+	// the peer, and the datagrams from others are dropped, so only enable it
+	// when the conn is not reachable by untrusted hosts.
+	Learn bool
+
+	// Sequence prefix sequence number to data datagram, so that peer can
+	// detect lost and reordered datagrams
+	Sequence bool
+
+	// Keepalive send keepalive datagram if nothing sent in the interval, to
+	// keep NAT mapping, 0 means disable, not less than 10ms
+	Keepalive time.Duration
+
+	// MTU path MTU between tunnel endpoints, default 1500
+	MTU int
+}
+
+func (c *Config) init() {
+	if c.Keepalive > 0 {
+		c.Keepalive = max(c.Keepalive, minKeepalive)
+	}
+	if c.MTU <= 0 {
+		c.MTU = 1500
+	}
+}
+
+type Stats struct {
+	TxPackets, RxPackets uint64
+
+	// TxDropped the packets dropped because peer unknown
+	TxDropped uint64
+	// RxInvalid the invalid datagrams
+	RxInvalid uint64
+	// RxLost and RxReordered detected by sequence number, the reordered
+	// datagram also counted as lost when the later one received
+	RxLost, RxReordered uint64
+	// Keepalives received keepalive datagrams
+	Keepalives uint64
+}
+
+// Tunnel encapsulate packets received from Device in UDP datagrams to peer,
+// and send the ip packets in datagrams received from peer by Device
+type Tunnel struct {
+	dev  wintun.Device
+	conn net.PacketConn
+	cfg  Config
+
+	peer     atomic.Pointer[netip.AddrPort]
+	seq      atomic.Uint32
+	lastSend atomic.Int64 // unix nano
+
+	// receive sequence state, only accessed by recv goroutine
+	rseq struct {
+		next  uint32
+		valid bool
+	}
+
+	stats struct {
+		txPackets, rxPackets, txDropped, rxInvalid atomic.Uint64
+		rxLost, rxReordered, keepalives            atomic.Uint64
+	}
+
+	closeOnce sync.Once
+}
+
+func New(dev wintun.Device, conn net.PacketConn, cfg Config) *Tunnel {
+	cfg.init()
+
+	var t = &Tunnel{dev: dev, conn: conn, cfg: cfg}
+	if cfg.Peer.IsValid() {
+		// the received address is unmapped
+		peer := netip.AddrPortFrom(cfg.Peer.Addr().Unmap(), cfg.Peer.Port())
+		t.peer.Store(&peer)
+	}
+	return t
+}
+
+// Peer get current peer address, invalid if not learned
+func (t *Tunnel) Peer() netip.AddrPort {
+	if p := t.peer.Load(); p != nil {
+		return *p
+	}
+	return netip.AddrPort{}
+}
+
+// MTU get the inner MTU that adapter should use, avoid outer fragmentation
+func (t *Tunnel) MTU() int {
+	peer := t.Peer()
+	if !peer.IsValid() {
+		// assume IPv6 peer, the larger overhead
+		peer = netip.AddrPortFrom(netip.IPv6Unspecified(), 0)
+	}
+	return t.cfg.MTU - Overhead(peer.Addr(), t.cfg.Sequence)
+}
+
+func (t *Tunnel) Stats() Stats {
+	return Stats{
+		TxPackets:   t.stats.txPackets.Load(),
+		RxPackets:   t.stats.rxPackets.Load(),
+		TxDropped:   t.stats.txDropped.Load(),
+		RxInvalid:   t.stats.rxInvalid.Load(),
+		RxLost:      t.stats.rxLost.Load(),
+		RxReordered: t.stats.rxReordered.Load(),
+		Keepalives:  t.stats.keepalives.Load(),
+	}
+}
+
+// Serve relay packets between Device and peer, blocks until ctx done or
+// Device/conn failed, return nil if ctx done. the conn is closed after return.
+func (t *Tunnel) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { t.Close() })
+	defer stop()
+
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, 3)
+	)
+	run := func(fn func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx); err != nil && ctx.Err() == nil {
+				errs <- err
+			}
+			cancel()
+		}()
+	}
+	run(t.send)
+	run(t.recv)
+	if t.cfg.Keepalive > 0 {
+		run(t.keepalive)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+func (t *Tunnel) send(ctx context.Context) error {
+	var b = make([]byte, hdrSize+seqSize+0xffff)
+	for {
+		ip, err := t.dev.Recv(ctx)
+		if err != nil {
+			return err
+		}
+
+		peer := t.peer.Load()
+		if peer == nil {
+			t.stats.txDropped.Add(1)
+			if err := t.dev.Release(ip); err != nil {
+				return err
+			}
+			continue
+		}
+
+		n := t.header(b, typeData)
+		n += copy(b[n:], ip)
+		if err := t.dev.Release(ip); err != nil {
+			return err
+		}
+
+		if err := t.write(b[:n], *peer); err != nil {
+			return err
+		}
+		t.stats.txPackets.Add(1)
+	}
+}
+
+// header encode header into b, return header length
+func (t *Tunnel) header(b []byte, typ uint8) int {
+	b[0] = version<<4 | typ
+	b[1], b[2], b[3] = 0, 0, 0
+	if typ == typeData && t.cfg.Sequence {
+		b[1] |= flagSeq
+		binary.BigEndian.PutUint32(b[hdrSize:], t.seq.Add(1)-1)
+		return hdrSize + seqSize
+	}
+	return hdrSize
+}
+
+func (t *Tunnel) write(b []byte, peer netip.AddrPort) error {
+	_, err := t.conn.WriteTo(b, net.UDPAddrFromAddrPort(peer))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	t.lastSend.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (t *Tunnel) recv(ctx context.Context) error {
+	var b = make([]byte, 0xffff)
+	for {
+		n, addr, err := t.conn.ReadFrom(b)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		from := addr.(*net.UDPAddr).AddrPort()
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+
+		// check peer before observe sequence, the datagrams from others
+		// must not affect statistics
+		peer := t.peer.Load()
+		if peer != nil && *peer != from || peer == nil && !t.cfg.Learn {
+			t.stats.rxInvalid.Add(1)
+			continue
+		}
+		typ, data, err := parse(b[:n])
+		if err != nil {
+			t.stats.rxInvalid.Add(1)
+			continue
+		} else if peer == nil {
+			t.peer.Store(&from)
+		}
+		if seq, ok := sequence(b[:n]); ok {
+			t.observe(seq)
+		}
+
+		switch typ {
+		case typeKeepalive:
+			t.stats.keepalives.Add(1)
+		case typeData:
+			if len(data) == 0 {
+				continue
+			}
+			if err := wintun.WritePacket(t.dev, data); err != nil {
+				if errors.Is(err, wintun.ErrRingFull{}) {
+					continue
+				}
+				return err
+			}
+			t.stats.rxPackets.Add(1)
+		}
+	}
+}
+
+// parse parse datagram, the data not include sequence number
+func parse(b []byte) (typ uint8, data []byte, err error) {
+	if len(b) < hdrSize || b[0]>>4 != version {
+		return 0, nil, errors.WithStack(ErrInvalidDatagram{})
+	}
+	typ, data = b[0]&0xf, b[hdrSize:]
+	if typ != typeData && typ != typeKeepalive {
+		return 0, nil, errors.WithStack(ErrInvalidDatagram{})
+	}
+
+	if typ == typeData && b[1]&flagSeq != 0 {
+		if len(data) < seqSize {
+			return 0, nil, errors.WithStack(ErrInvalidDatagram{})
+		}
+		data = data[seqSize:]
+	}
+	return typ, data, nil
+}
+
+// sequence get sequence number of parsed datagram, false if not carry
+func sequence(b []byte) (uint32, bool) {
+	if b[0]&0xf != typeData || b[1]&flagSeq == 0 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b[hdrSize:]), true
+}
+
+// observe update lost and reordered statistics by sequence number
+func (t *Tunnel) observe(seq uint32) {
+	if !t.rseq.valid {
+		t.rseq.next, t.rseq.valid = seq+1, true
+		return
+	}
+	switch d := int32(seq - t.rseq.next); {
+	case d == 0:
+		t.rseq.next++
+	case d > 0:
+		t.stats.rxLost.Add(uint64(d))
+		t.rseq.next = seq + 1
+	default:
+		t.stats.rxReordered.Add(1)
+	}
+}
+
+func (t *Tunnel) keepalive(ctx context.Context) error {
+	ticker := time.NewTicker(t.cfg.Keepalive / 2)
+	defer ticker.Stop()
+
+	var b [hdrSize]byte
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		peer := t.peer.Load()
+		if peer == nil || time.Since(time.Unix(0, t.lastSend.Load())) < t.cfg.Keepalive {
+			continue
+		}
+		t.header(b[:], typeKeepalive)
+		if err := t.write(b[:], *peer); err != nil {
+			return err
+		}
+	}
+}
+
+// Close close the underlying conn
+func (t *Tunnel) Close() error {
+	var err error
+	t.closeOnce.Do(func() { err = errors.WithStack(t.conn.Close()) })
+	return err
+}
@@ -0,0 +1,228 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type memDevice struct {
+	in, out chan []byte
+}
+
+var _ wintun.Device = (*memDevice)(nil)
+
+func newMemDevice() *memDevice {
+	return &memDevice{in: make(chan []byte, 16), out: make(chan []byte, 16)}
+}
+
+func (d *memDevice) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case ip := <-d.in:
+		return ip, nil
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+func (d *memDevice) Release(ip []byte) error        { return nil }
+func (d *memDevice) Alloc(size int) ([]byte, error) { return make([]byte, size), nil }
+func (d *memDevice) Send(ip []byte) error           { d.out <- ip; return nil }
+func (d *memDevice) recv(t *testing.T) (ip []byte) {
+	select {
+	case ip = <-d.out:
+		return ip
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func listen(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return conn
+}
+
+func localAddr(conn net.PacketConn) netip.AddrPort {
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func buildUDP(t *testing.T, payload string) []byte {
+	var (
+		h   = packet.IP{Src: netip.MustParseAddr("10.0.0.1"), Dst: netip.MustParseAddr("10.0.0.2")}
+		udp = &packet.UDPSegment{SrcPort: 1234, DstPort: 5678, Payload: []byte(payload)}
+	)
+	n, err := packet.Size(h, udp)
+	require.NoError(t, err)
+	var b = make([]byte, n)
+	_, err = packet.Build(b, h, udp)
+	require.NoError(t, err)
+	return b
+}
+
+func Test_Tunnel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		connA, connB = listen(t), listen(t)
+		devA, devB   = newMemDevice(), newMemDevice()
+	)
+	a := New(devA, connA, Config{Peer: localAddr(connB), Sequence: true})
+	// b learn peer from first received datagram
+	b := New(devB, connB, Config{Sequence: true, Learn: true})
+	require.False(t, b.Peer().IsValid())
+
+	errs := make(chan error, 2)
+	go func() { errs <- a.Serve(ctx) }()
+	go func() { errs <- b.Serve(ctx) }()
+
+	for i, msg := range []string{"hello", "world"} {
+		ip := buildUDP(t, msg)
+		devA.in <- ip
+		require.Equal(t, ip, devB.recv(t), i)
+	}
+	require.Equal(t, localAddr(connA), b.Peer())
+
+	ip := buildUDP(t, "reply")
+	devB.in <- ip
+	require.Equal(t, ip, devA.recv(t))
+
+	require.Equal(t, Stats{TxPackets: 2, RxPackets: 1}, a.Stats())
+	require.Equal(t, Stats{TxPackets: 1, RxPackets: 2}, b.Stats())
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+func Test_Sequence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		conn, peer = listen(t), listen(t)
+		dev        = newMemDevice()
+		tun        = New(dev, conn, Config{Peer: localAddr(peer)})
+	)
+	defer peer.Close()
+	go tun.Serve(ctx)
+
+	ip := buildUDP(t, "seq")
+	write := func(seq uint32) {
+		var b = make([]byte, hdrSize+seqSize+len(ip))
+		b[0], b[1] = version<<4|typeData, flagSeq
+		binary.BigEndian.PutUint32(b[hdrSize:], seq)
+		copy(b[hdrSize+seqSize:], ip)
+		_, err := peer.WriteTo(b, net.UDPAddrFromAddrPort(localAddr(conn)))
+		require.NoError(t, err)
+		require.Equal(t, ip, dev.recv(t))
+	}
+	for _, seq := range []uint32{0xfffffffe, 0xffffffff, 2, 1, 3} {
+		write(seq)
+	}
+
+	s := tun.Stats()
+	require.Equal(t, uint64(5), s.RxPackets)
+	require.Equal(t, uint64(2), s.RxLost)
+	require.Equal(t, uint64(1), s.RxReordered)
+
+	// invalid datagram and datagram from unknown address are ignored
+	_, err := peer.WriteTo([]byte{0xff, 0, 0, 0}, net.UDPAddrFromAddrPort(localAddr(conn)))
+	require.NoError(t, err)
+	other := listen(t)
+	defer other.Close()
+	_, err = other.WriteTo([]byte{version<<4 | typeKeepalive, 0, 0, 0}, net.UDPAddrFromAddrPort(localAddr(conn)))
+	require.NoError(t, err)
+
+	// sequence of datagram from unknown address not affect statistics
+	var b = make([]byte, hdrSize+seqSize+len(ip))
+	b[0], b[1] = version<<4|typeData, flagSeq
+	binary.BigEndian.PutUint32(b[hdrSize:], 100)
+	copy(b[hdrSize+seqSize:], ip)
+	_, err = other.WriteTo(b, net.UDPAddrFromAddrPort(localAddr(conn)))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return tun.Stats().RxInvalid == 3 }, time.Second*5, time.Millisecond*10)
+	require.Equal(t, uint64(2), tun.Stats().RxLost)
+
+	write(4)
+	require.Equal(t, uint64(2), tun.Stats().RxLost)
+}
+
+func Test_Keepalive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		connA, connB = listen(t), listen(t)
+		a            = New(newMemDevice(), connA, Config{Peer: localAddr(connB), Keepalive: time.Millisecond * 50})
+		b            = New(newMemDevice(), connB, Config{Learn: true})
+	)
+	go a.Serve(ctx)
+	go b.Serve(ctx)
+
+	require.Eventually(t, func() bool { return b.Stats().Keepalives >= 2 }, time.Second*5, time.Millisecond*10)
+	require.Equal(t, localAddr(connA), b.Peer())
+	require.Zero(t, b.Stats().RxPackets)
+	// too small interval is clamped
+	require.Equal(t, minKeepalive, New(nil, nil, Config{Keepalive: 1}).cfg.Keepalive)
+}
+
+func Test_Peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("mapped", func(t *testing.T) {
+		var (
+			connA, connB = listen(t), listen(t)
+			devA, devB   = newMemDevice(), newMemDevice()
+			addrB        = localAddr(connB)
+			mapped       = netip.AddrPortFrom(netip.AddrFrom16(addrB.Addr().As16()), addrB.Port())
+			a            = New(devA, connA, Config{Peer: mapped})
+			b            = New(devB, connB, Config{Peer: localAddr(connA)})
+		)
+		go a.Serve(ctx)
+		go b.Serve(ctx)
+		require.Equal(t, addrB, a.Peer())
+
+		ip := buildUDP(t, "mapped")
+		devB.in <- ip
+		require.Equal(t, ip, devA.recv(t))
+		require.Zero(t, a.Stats().RxInvalid)
+	})
+
+	t.Run("not-learn", func(t *testing.T) {
+		var (
+			connA, connB = listen(t), listen(t)
+			devA         = newMemDevice()
+			a            = New(devA, connA, Config{})
+			b            = New(newMemDevice(), connB, Config{Peer: localAddr(connA), Keepalive: time.Millisecond * 20})
+		)
+		go a.Serve(ctx)
+		go b.Serve(ctx)
+
+		require.Eventually(t, func() bool { return a.Stats().RxInvalid >= 2 }, time.Second*5, time.Millisecond*10)
+		require.False(t, a.Peer().IsValid())
+		require.Zero(t, a.Stats().Keepalives)
+	})
+}
+
+func Test_MTU(t *testing.T) {
+	var (
+		v4 = netip.MustParseAddrPort("192.168.0.1:1")
+		v6 = netip.MustParseAddrPort("[fe80::1]:1")
+	)
+	require.Equal(t, 1500-20-8-4, New(nil, nil, Config{Peer: v4}).MTU())
+	require.Equal(t, 1500-20-8-8, New(nil, nil, Config{Peer: v4, Sequence: true}).MTU())
+	require.Equal(t, 1400-40-8-4, New(nil, nil, Config{Peer: v6, MTU: 1400}).MTU())
+	// unknown peer assume IPv6
+	require.Equal(t, 1500-40-8-4, New(nil, nil, Config{}).MTU())
+}
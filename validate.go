@@ -0,0 +1,44 @@
+package wintun
+
+import (
+	"math/bits"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// validateAdapter validate CreateAdapter parameters before adapter created
+func validateAdapter(name, tunType string, capacity uint32) error {
+	if err := validateName("adapter name", name); err != nil {
+		return err
+	}
+	if err := validateName("tunnel type", tunType); err != nil {
+		return err
+	}
+	return validateCapacity(capacity)
+}
+
+func validateName(field, name string) error {
+	if len(name) == 0 {
+		return errors.Errorf("require %s", field)
+	}
+	for _, r := range name {
+		if r == 0 {
+			return errors.Errorf("%s %q contain null character", field, name)
+		}
+	}
+	if n := len(utf16.Encode([]rune(name))); n >= MaxAdapterName {
+		return errors.Errorf("%s %q too long, %d UTF-16 code units", field, name, n)
+	}
+	return nil
+}
+
+// validateCapacity ring capacity must be power of two between
+// MinRingCapacity and MaxRingCapacity
+func validateCapacity(capacity uint32) error {
+	if capacity < MinRingCapacity || MaxRingCapacity < capacity ||
+		bits.OnesCount32(capacity) != 1 {
+		return errors.WithStack(invalidParameter(ErrInvalidCapacity{}))
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package wintun
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Validate_Adapter(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		require.NoError(t, validateAdapter("test", DefaultTunType, MinRingCapacity))
+		require.NoError(t, validateAdapter("测试", "test", MaxRingCapacity))
+		require.NoError(t, validateAdapter(strings.Repeat("a", MaxAdapterName-1), "test", MinRingCapacity))
+	})
+
+	t.Run("name", func(t *testing.T) {
+		require.Error(t, validateAdapter("", DefaultTunType, MinRingCapacity))
+		require.Error(t, validateAdapter("te\x00st", DefaultTunType, MinRingCapacity))
+		require.Error(t, validateAdapter(strings.Repeat("a", MaxAdapterName), DefaultTunType, MinRingCapacity))
+
+		// surrogate pair occupy two UTF-16 code units
+		require.Error(t, validateAdapter(strings.Repeat("😀", MaxAdapterName/2), DefaultTunType, MinRingCapacity))
+	})
+
+	t.Run("tunnel-type", func(t *testing.T) {
+		require.Error(t, validateAdapter("test", "", MinRingCapacity))
+		require.Error(t, validateAdapter("test", strings.Repeat("a", MaxAdapterName), MinRingCapacity))
+	})
+
+	t.Run("capacity", func(t *testing.T) {
+		for _, c := range []uint32{0, MinRingCapacity - 1, MinRingCapacity / 2, MinRingCapacity + 1, MaxRingCapacity * 2, MinRingCapacity * 3} {
+			err := validateAdapter("test", DefaultTunType, c)
+			require.True(t, errors.Is(err, ErrInvalidCapacity{}), c)
+		}
+	})
+}
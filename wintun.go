@@ -4,6 +4,8 @@
 package wintun
 
 import (
+	"runtime"
+	"sync"
 	"syscall"
 	"unsafe"
 
@@ -56,14 +58,17 @@ var (
 )
 
 func CreateAdapter(name string, opts ...Option) (*Adapter, error) {
-	if len(name) == 0 {
-		return nil, errors.New("require adapter name")
-	}
-
 	var o = defaultOptions()
 	for _, fn := range opts {
 		fn(o)
 	}
+	if err := validateAdapter(name, o.tunType, o.ringBuff); err != nil {
+		return nil, err
+	}
+	if o.guid == nil && o.namespace != nil {
+		guid := windows.GUID(DeriveGuid(*o.namespace, name))
+		o.guid = &guid
+	}
 
 	name16, err := windows.UTF16PtrFromString(name)
 	if err != nil {
@@ -73,6 +78,17 @@ func CreateAdapter(name string, opts ...Option) (*Adapter, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	if o.guid == nil {
+		guid, err := windows.GenerateGUID()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		o.guid = &guid
+	}
+	owner, err := markOwner(o.guid)
+	if err != nil {
+		return nil, err
+	}
 
 	r1, _, err := syscall.SyscallN(
 		procCreateAdapter.Addr(),
@@ -80,16 +96,24 @@ func CreateAdapter(name string, opts ...Option) (*Adapter, error) {
 		uintptr(unsafe.Pointer(tunnelType16)),
 		uintptr(unsafe.Pointer(o.guid)),
 	)
-	if err != windows.ERROR_SUCCESS {
-		return nil, errors.WithStack(err)
+	if err := errnoErr(err); err != nil {
+		unmarkOwner(o.guid, owner)
+		return nil, err
 	}
-	ap := &Adapter{handle: r1}
-	return ap, ap.Start(o.ringBuff)
+	return newAdapter(r1, o, owner)
 }
 
-func OpenAdapter(name string) (*Adapter, error) {
-	if len(name) == 0 {
-		return nil, errors.New("require adapter name")
+// OpenAdapter open existing adapter, the options TunType, Guid and DerivedGuid
+// are ignored
+func OpenAdapter(name string, opts ...Option) (*Adapter, error) {
+	var o = defaultOptions()
+	for _, fn := range opts {
+		fn(o)
+	}
+	if err := validateName("adapter name", name); err != nil {
+		return nil, err
+	} else if err := validateCapacity(o.ringBuff); err != nil {
+		return nil, err
 	}
 
 	var name16 *uint16
@@ -99,26 +123,89 @@ func OpenAdapter(name string) (*Adapter, error) {
 	}
 
 	r1, _, err := syscall.SyscallN(procOpenAdapter.Addr(), uintptr(unsafe.Pointer(name16)))
-	if err != windows.ERROR_SUCCESS {
-		return nil, errors.WithStack(err)
+	if err := errnoErrAs(err, map[windows.Errno]error{
+		windows.ERROR_FILE_NOT_FOUND: ErrAdapterNotFound{},
+	}); err != nil {
+		return nil, err
 	}
-	ap := &Adapter{handle: r1}
-	return ap, ap.Start(MinRingCapacity)
+	return newAdapter(r1, o, 0)
+}
+
+// newAdapter init adapter by options, the handle will be closed if failed,
+// owner is the ownership marker if the adapter is created by this process
+func newAdapter(handle uintptr, o *options, owner windows.Handle) (*Adapter, error) {
+	ap := &Adapter{handle: handle, owner: owner}
+	if owner != 0 {
+		ap.ownerGuid = *o.guid
+	}
+
+	var err error
+	if o.start {
+		err = ap.Start(o.ringBuff)
+	}
+	if err != nil {
+		ap.Close()
+		return nil, err
+	}
+
+	if o.adaptive != nil {
+		ap.done = make(chan struct{})
+		go ap.adaptive(*o.adaptive, ap.done)
+	}
+	return ap, nil
 }
 
 // todo: https://git.zx2c4.com/wintun-go/tree/wintun.go
 func DriverVersion() (version uint32, err error) {
 	r0, _, err := syscall.SyscallN(procGetRunningDriverVersion.Addr())
-	if err != windows.ERROR_SUCCESS {
-		return 0, errors.WithStack(err)
+	if err := errnoErrAs(err, map[windows.Errno]error{
+		windows.ERROR_FILE_NOT_FOUND: ErrDriverNotInstalled{},
+	}); err != nil {
+		return 0, err
 	}
 	return uint32(r0), nil
 }
 
 func DeleteDriver() error {
 	_, _, err := syscall.SyscallN(procDeleteDriver.Addr())
-	if err != windows.ERROR_SUCCESS {
-		return errors.WithStack(err)
+	return errnoErr(err)
+}
+
+var (
+	trampolineOnce sync.Once
+	trampoline     uintptr
+	trampolineErr  error
+)
+
+// SetLogger set wintun global logger, it can be called repeatedly, nil means
+// disable logging
+func SetLogger(logger LoggerCallback) error {
+	trampolineOnce.Do(func() {
+		switch runtime.GOARCH {
+		case "386":
+			trampoline = windows.NewCallback(func(level LoggerLevel, timestampLow, timestampHigh uint32, msg *uint16) uintptr {
+				return dispatchLog(level, uint64(timestampHigh)<<32|uint64(timestampLow), msg)
+			})
+		case "arm":
+			trampoline = windows.NewCallback(func(level LoggerLevel, _, timestampLow, timestampHigh uint32, msg *uint16) uintptr {
+				return dispatchLog(level, uint64(timestampHigh)<<32|uint64(timestampLow), msg)
+			})
+		case "amd64", "arm64":
+			trampoline = windows.NewCallback(dispatchLog)
+		default:
+			trampolineErr = errors.Errorf("not support windows arch %s", runtime.GOARCH)
+		}
+	})
+	if trampolineErr != nil {
+		return trampolineErr
 	}
-	return nil
+
+	storeLogger(logger)
+
+	var callback uintptr
+	if logger != nil {
+		callback = trampoline
+	}
+	_, _, err := syscall.SyscallN(procSetLogger.Addr(), callback)
+	return errnoErr(err)
 }
@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 package wintun_test
 
 import (
@@ -7,16 +10,14 @@ import (
 	"log/slog"
 	"math/rand"
 	"net/netip"
-	"os"
 	"os/exec"
 	"runtime"
 	"testing"
 	"time"
 
 	"github.com/lysShub/wintun-go"
+	"github.com/lysShub/wintun-go/packet"
 	"github.com/stretchr/testify/require"
-	"gvisor.dev/gvisor/pkg/tcpip"
-	"gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -95,25 +96,16 @@ func Test_Example(t *testing.T) {
 	go func() {
 		defer close(ch)
 
-		pack := buildICMP(t,
-			addr.Addr().Next().AsSlice(),
-			addr.Addr().AsSlice(),
-			header.ICMPv4Echo, []byte("1234"),
+		var (
+			hdr  = packet.IP{Src: addr.Addr().Next(), Dst: addr.Addr()}
+			echo = &packet.Echo{Data: []byte("1234")}
 		)
 		for {
-			p, err := ap.Alloc(len(pack))
+			err := wintun.Inject(ap.Device(), hdr, echo)
 			if errors.Is(err, wintun.ErrAdapterClosed{}) {
 				return
 			}
 			require.NoError(t, err)
-
-			copy(p, pack)
-
-			err = ap.Send(p)
-			require.NoError(t, err)
-			if errors.Is(err, os.ErrClosed) {
-				return
-			}
 			time.Sleep(time.Second)
 		}
 	}()
@@ -185,6 +177,25 @@ func Test_Logger(t *testing.T) {
 
 		require.Contains(t, buff.String(), "Creating")
 	})
+	t.Run("min-level", func(t *testing.T) {
+		wintun.MustLoad(wintun.DLL)
+
+		buff := bytes.NewBuffer(nil)
+		log := slog.New(slog.NewJSONHandler(buff, nil))
+		callback := wintun.DefaultCallback(log, wintun.MinLevel(slog.LevelError))
+
+		err := wintun.SetLogger(callback)
+		require.NoError(t, err)
+
+		{
+			w, err := wintun.CreateAdapter("testloggerminlevel")
+			require.NoError(t, err)
+			err = w.Close()
+			require.NoError(t, err)
+		}
+
+		require.NotContains(t, buff.String(), "Creating")
+	})
 	t.Run("file", func(t *testing.T) {
 		t.Skip("require independent test")
 		require.NoError(t, wintun.Load(dllPath))
@@ -214,6 +225,13 @@ func Test_Open(t *testing.T) {
 		require.True(t, errors.Is(err, wintun.ErrNotLoad{}))
 		require.Nil(t, ap)
 	})
+	t.Run("open/not-found", func(t *testing.T) {
+		wintun.MustLoad(wintun.DLL)
+
+		ap, err := wintun.OpenAdapter("testopennotfound")
+		require.True(t, errors.Is(err, wintun.ErrAdapterNotFound{}), err)
+		require.Nil(t, ap)
+	})
 }
 
 func randPort() int {
@@ -240,33 +258,3 @@ func init() {
 		panic("")
 	}
 }
-
-func buildICMP(t require.TestingT, src, dst []byte, typ header.ICMPv4Type, msg []byte) []byte {
-	require.Zero(t, len(msg)%4)
-
-	var p = make([]byte, 28+len(msg))
-	iphdr := header.IPv4(p)
-	iphdr.Encode(&header.IPv4Fields{
-		TOS:            0,
-		TotalLength:    uint16(len(p)),
-		ID:             uint16(rand.Uint32()),
-		Flags:          0,
-		FragmentOffset: 0,
-		TTL:            128,
-		Protocol:       uint8(header.ICMPv4ProtocolNumber),
-		Checksum:       0,
-		SrcAddr:        tcpip.AddrFromSlice(src),
-		DstAddr:        tcpip.AddrFromSlice(dst),
-	})
-	iphdr.SetChecksum(^checksum.Checksum(p[:iphdr.HeaderLength()], 0))
-	require.True(t, iphdr.IsChecksumValid())
-
-	icmphdr := header.ICMPv4(iphdr.Payload())
-	icmphdr.SetType(typ)
-	icmphdr.SetIdent(0)
-	icmphdr.SetSequence(0)
-	icmphdr.SetChecksum(0)
-	copy(icmphdr.Payload(), msg)
-	icmphdr.SetChecksum(^checksum.Checksum(icmphdr, 0))
-	return p
-}